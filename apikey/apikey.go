@@ -0,0 +1,68 @@
+// Package apikey generates and checks the API keys callers present to the
+// service. A key is "<prefix>_<id>_<secret>": the ID locates the stored
+// record, the secret is only ever stored as a hash.
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Key prefixes tell the kinds of keys apart at a glance.
+const (
+	AdminPrefix        = "adm"
+	ClientSecretPrefix = "cs"
+	TenantPrefix       = "tk"
+)
+
+// Generate returns a new key with the prefix, its ID and the hash to store.
+func Generate(prefix string) (key, id, hash string, err error) {
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", "", "", fmt.Errorf("failed to generate key ID: %w", err)
+	}
+	id = hex.EncodeToString(idBytes)
+	key, hash, err = Regenerate(prefix, id)
+	return key, id, hash, err
+}
+
+// Regenerate returns a key with a new secret for an existing ID, and the
+// hash to store.
+func Regenerate(prefix, id string) (key, hash string, err error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", fmt.Errorf("failed to generate key: %w", err)
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(secret)
+	return prefix + "_" + id + "_" + encoded, Hash(encoded), nil
+}
+
+// Parse splits a key with the prefix into its ID and secret.
+func Parse(prefix, key string) (id, secret string, ok bool) {
+	rest, ok := strings.CutPrefix(key, prefix+"_")
+	if !ok {
+		return "", "", false
+	}
+	id, secret, ok = strings.Cut(rest, "_")
+	if !ok || len(id) != 16 || secret == "" {
+		return "", "", false
+	}
+	return id, secret, true
+}
+
+// Hash returns the hex SHA-256 digest under which a secret is stored.
+// Secrets are random, so a fast hash is sufficient.
+func Hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Matches reports in constant time whether secret hashes to hash.
+func Matches(secret, hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(Hash(secret)), []byte(hash)) == 1
+}
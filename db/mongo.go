@@ -2,12 +2,18 @@ package db
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// ErrDuplicateClientEmails is returned by EnsureIndexes when registered
+// clients share an email, so that the unique index cannot be built.
+var ErrDuplicateClientEmails = errors.New("several clients share an email")
+
 var MongoClient *mongo.Client
 
 func ConnectMongoDB(uri string) error {
@@ -23,3 +29,70 @@ func ConnectMongoDB(uri string) error {
 func GetClientsCollection() *mongo.Collection {
 	return MongoClient.Database("clients").Collection("clients")
 }
+
+// GetAdminKeysCollection holds the hashed admin API keys.
+func GetAdminKeysCollection() *mongo.Collection {
+	return MongoClient.Database("clients").Collection("admin_keys")
+}
+
+// GetAPIKeysCollection holds the hashed API keys of all clients.
+func GetAPIKeysCollection() *mongo.Collection {
+	return MongoClient.Database("clients").Collection("api_keys")
+}
+
+// GetOwnersCollection holds the accounts owning clients.
+func GetOwnersCollection() *mongo.Collection {
+	return MongoClient.Database("clients").Collection("owners")
+}
+
+// GetSchemaMigrationsCollection records the schema migrations of all
+// clients.
+func GetSchemaMigrationsCollection() *mongo.Collection {
+	return MongoClient.Database("clients").Collection("schema_migrations")
+}
+
+// EnsureIndexes creates the indexes of the control plane. Client emails are
+// unique unless empty. The other indexes are created even if existing
+// clients share an email, in which case ErrDuplicateClientEmails is
+// returned.
+func EnsureIndexes(ctx context.Context) error {
+	indexes := []struct {
+		collection *mongo.Collection
+		model      mongo.IndexModel
+	}{
+		{GetOwnersCollection(), mongo.IndexModel{
+			Keys:    bson.D{{Key: "email", Value: 1}},
+			Options: options.Index().SetName("uq_email").SetUnique(true),
+		}},
+		{GetClientsCollection(), mongo.IndexModel{
+			Keys:    bson.D{{Key: "owner_id", Value: 1}},
+			Options: options.Index().SetName("idx_owner_id"),
+		}},
+		{GetAPIKeysCollection(), mongo.IndexModel{
+			Keys:    bson.D{{Key: "client_id", Value: 1}},
+			Options: options.Index().SetName("idx_client_id"),
+		}},
+		{GetSchemaMigrationsCollection(), mongo.IndexModel{
+			Keys:    bson.D{{Key: "client_id", Value: 1}, {Key: "started_at", Value: 1}},
+			Options: options.Index().SetName("idx_client_id_started_at"),
+		}},
+	}
+	for _, index := range indexes {
+		if _, err := index.collection.Indexes().CreateOne(ctx, index.model); err != nil {
+			return fmt.Errorf("failed to create index on %s: %w", index.collection.Name(), err)
+		}
+	}
+
+	_, err := GetClientsCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "email", Value: 1}},
+		Options: options.Index().SetName("uq_email").SetUnique(true).
+			SetPartialFilterExpression(bson.M{"email": bson.M{"$gt": ""}}),
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicateClientEmails
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create index on clients: %w", err)
+	}
+	return nil
+}
@@ -1,14 +1,46 @@
 package db
 
 import (
+	"auth-service/password"
+	"auth-service/schema"
 	"database/sql"
+	"errors"
 	"fmt"
+	"regexp"
+	"strings"
+	"sync"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 )
 
 var MySQLClient *sql.DB
 
+// tokenTables remembers the clients whose token tables are known to exist.
+var tokenTables sync.Map
+
+// widenedPasswordColumns remembers the clients whose password column is
+// known to be wide enough for encoded hashes.
+var widenedPasswordColumns sync.Map
+
+// ErDupEntry is the MySQL error number of a duplicate key.
+const ErDupEntry = 1062
+
+var duplicateKeyPattern = regexp.MustCompile(`for key '(?:[^'.]*\.)?([^']*)'`)
+
+// DuplicateKey reports whether err is a duplicate key error and returns the
+// name of the violated index.
+func DuplicateKey(err error) (string, bool) {
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) || mysqlErr.Number != ErDupEntry {
+		return "", false
+	}
+	// The duplicate value comes first in the message, so take the last match
+	if matches := duplicateKeyPattern.FindAllStringSubmatch(mysqlErr.Message, -1); matches != nil {
+		return matches[len(matches)-1][1], true
+	}
+	return "", true
+}
+
 func ConnectMySQL(dsn string) error {
 	db, err := sql.Open("mysql", dsn)
 	if err != nil {
@@ -18,34 +50,127 @@ func ConnectMySQL(dsn string) error {
 	return nil
 }
 
-func CreateUserTable(clientID string, schema map[string]string) error {
-	// Create a new MySQL database for this client
+// CreateUserTable creates the client's database and its users table with a
+// column for each schema field, in schema order.
+func CreateUserTable(clientID string, fields []schema.Field) error {
+	statements := userTableStatements(fmt.Sprintf("client_%s", clientID), fields)
+	steps := []string{"create database", "create table"}
+	for i, statement := range statements {
+		if _, err := MySQLClient.Exec(statement); err != nil {
+			return fmt.Errorf("failed to %s: %w", steps[i], err)
+		}
+	}
+	fmt.Println("User table created successfully")
+
+	return EnsureTokenTables(clientID)
+}
+
+// ClientStatements returns the DDL that CreateUserTable runs for a client,
+// including its token tables.
+func ClientStatements(clientID string, fields []schema.Field) []string {
 	dbName := fmt.Sprintf("client_%s", clientID)
-	_, err := MySQLClient.Exec(fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s", dbName))
-	if err != nil {
-		return fmt.Errorf("failed to create database: %w", err)
+	return append(userTableStatements(dbName, fields), tokenTableStatements(dbName)...)
+}
+
+// userTableStatements returns the DDL creating a client's database and its
+// users table.
+func userTableStatements(dbName string, fields []schema.Field) []string {
+	return []string{
+		fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s", dbName),
+		schema.CreateTable(dbName, fields),
 	}
-	fmt.Println("Database created successfully")
+}
 
-	// Switch to the new database
-	_, err = MySQLClient.Exec(fmt.Sprintf("USE %s", dbName))
-	if err != nil {
-		return fmt.Errorf("failed to switch to database %s: %w", dbName, err)
+// tokenTableStatements returns the DDL creating the tables holding a
+// client's refresh tokens and revocations. Refresh tokens are stored as
+// SHA-256 hashes and grouped into families, one per login session. Revoked
+// access tokens are kept until they expire; revoking all sessions of a user
+// records a cutoff time.
+func tokenTableStatements(dbName string) []string {
+	return []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.refresh_tokens (
+			token_hash CHAR(64) NOT NULL PRIMARY KEY,
+			family_id CHAR(32) NOT NULL,
+			subject VARCHAR(255) NOT NULL,
+			issued_at DATETIME NOT NULL,
+			expires_at DATETIME NOT NULL,
+			rotated_at DATETIME NULL,
+			revoked_at DATETIME NULL,
+			INDEX idx_refresh_tokens_family (family_id),
+			INDEX idx_refresh_tokens_subject (subject)
+		)`, dbName),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.revoked_tokens (
+			jti CHAR(32) NOT NULL PRIMARY KEY,
+			expires_at DATETIME NOT NULL
+		)`, dbName),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.session_revocations (
+			subject VARCHAR(255) NOT NULL PRIMARY KEY,
+			revoked_at DATETIME NOT NULL
+		)`, dbName),
 	}
+}
 
-	// Construct the CREATE TABLE statement
-	tableSchema := "CREATE TABLE IF NOT EXISTS users ("
-	for field, dataType := range schema {
-		tableSchema += fmt.Sprintf("%s %s,", field, dataType)
+// EnsureTokenTables creates the token tables of a client if they do not
+// exist yet.
+func EnsureTokenTables(clientID string) error {
+	if _, ok := tokenTables.Load(clientID); ok {
+		return nil
+	}
+	for _, statement := range tokenTableStatements(fmt.Sprintf("client_%s", clientID)) {
+		if _, err := MySQLClient.Exec(statement); err != nil {
+			return fmt.Errorf("failed to create token tables: %w", err)
+		}
 	}
-	tableSchema = tableSchema[:len(tableSchema)-1] + ")" // Remove trailing comma and add closing parenthesis
+	tokenTables.Store(clientID, true)
+	return nil
+}
 
-	// Execute the CREATE TABLE statement
-	_, err = MySQLClient.Exec(tableSchema)
+// EnsurePasswordColumn widens the password column of a client's user table so
+// that it can hold encoded hashes. Tables created before passwords were
+// hashed may use a narrower type.
+func EnsurePasswordColumn(clientID string) error {
+	if _, ok := widenedPasswordColumns.Load(clientID); ok {
+		return nil
+	}
+	dbName := fmt.Sprintf("client_%s", clientID)
+
+	var length sql.NullInt64
+	err := MySQLClient.QueryRow(
+		"SELECT CHARACTER_MAXIMUM_LENGTH FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = ? AND TABLE_NAME = 'users' AND COLUMN_NAME = ?",
+		dbName, password.Field,
+	).Scan(&length)
 	if err != nil {
-		return fmt.Errorf("failed to create table: %w", err)
+		return fmt.Errorf("failed to inspect password column: %w", err)
 	}
 
-	fmt.Println("User table created successfully")
+	if !length.Valid || length.Int64 < 255 {
+		_, err = MySQLClient.Exec(fmt.Sprintf("ALTER TABLE %s.users MODIFY %s %s", dbName, password.Field, password.ColumnType))
+		if err != nil {
+			return fmt.Errorf("failed to widen password column: %w", err)
+		}
+	}
+	widenedPasswordColumns.Store(clientID, true)
+	return nil
+}
+
+// DropClientDatabase drops a client's database with its users and tokens.
+// Dropping a database that no longer exists succeeds.
+func DropClientDatabase(clientID string) error {
+	dbName := fmt.Sprintf("client_%s", clientID)
+	if _, err := MySQLClient.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %s", dbName)); err != nil {
+		return fmt.Errorf("failed to drop database %s: %w", dbName, err)
+	}
+	tokenTables.Delete(clientID)
+	widenedPasswordColumns.Delete(clientID)
+	return nil
+}
+
+// AlterUserTable applies the clauses to a client's user table in one ALTER
+// TABLE statement, which either applies all of them or none.
+func AlterUserTable(clientID string, clauses []string) error {
+	statement := fmt.Sprintf("ALTER TABLE client_%s.users %s", clientID, strings.Join(clauses, ", "))
+	if _, err := MySQLClient.Exec(statement); err != nil {
+		return fmt.Errorf("failed to alter user table: %w", err)
+	}
 	return nil
 }
@@ -0,0 +1,105 @@
+package db
+
+import (
+	"auth-service/schema"
+	"crypto/rand"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// MySQL error numbers of a column or index that already exists.
+const (
+	erDupFieldName = 1060
+	erDupKeyName   = 1061
+)
+
+// ErrUserIDColumnConflict is returned for user tables with a user_id column
+// of their own, which has to be renamed before users can be given IDs.
+var ErrUserIDColumnConflict = errors.New("user table has a user_id column that is not a user ID")
+
+// NewUserID returns a random UUIDv7. Its leading timestamp keeps new IDs
+// roughly ordered, which keeps the unique index on them compact.
+func NewUserID() (string, error) {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(time.Now().UnixMilli())<<16)
+	if _, err := rand.Read(b[6:]); err != nil {
+		return "", fmt.Errorf("failed to generate user ID: %w", err)
+	}
+	b[6] = b[6]&0x0f | 0x70 // version 7
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+
+	s := hex.EncodeToString(b[:])
+	return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:], nil
+}
+
+// EnsureUserIDColumn adds the user ID column to a client's user table and
+// assigns an ID to every user that has none. Tables created before user IDs
+// were introduced lack the column. It is run at startup and when a client is
+// restored, before requests read the column, and several instances may run
+// it at once.
+func EnsureUserIDColumn(clientID string) error {
+	dbName := fmt.Sprintf("client_%s", clientID)
+
+	nullable, columnType, err := userIDColumn(dbName)
+	switch {
+	case err == nil && columnType != "char(36)":
+		// Created by the client before the name was reserved
+		return ErrUserIDColumnConflict
+	case err == nil && nullable == "NO":
+		return nil
+	case err == nil:
+		// A previous backfill was interrupted
+	case errors.Is(err, sql.ErrNoRows):
+		_, err = MySQLClient.Exec(fmt.Sprintf("ALTER TABLE %s.users ADD COLUMN `%s` CHAR(36) NULL", dbName, schema.UserIDColumn))
+		if err != nil && !isMySQLError(err, erDupFieldName) {
+			return fmt.Errorf("failed to add user ID column: %w", err)
+		}
+	default:
+		return err
+	}
+
+	// Existing users get MySQL's UUIDs in one statement; they are not
+	// ordered like those of NewUserID, but have the same format
+	_, err = MySQLClient.Exec(fmt.Sprintf("UPDATE %s.users SET `%[2]s` = UUID() WHERE `%[2]s` IS NULL", dbName, schema.UserIDColumn))
+	if err != nil {
+		return fmt.Errorf("failed to assign user IDs: %w", err)
+	}
+
+	_, err = MySQLClient.Exec(fmt.Sprintf("ALTER TABLE %s.users MODIFY `%[2]s` CHAR(36) NOT NULL, ADD UNIQUE KEY `uq_%[2]s` (`%[2]s`)", dbName, schema.UserIDColumn))
+	if isMySQLError(err, erDupKeyName) {
+		// Indexed by another instance in the meantime
+		nullable, _, err = userIDColumn(dbName)
+		if err == nil && nullable != "NO" {
+			err = fmt.Errorf("user ID column of %s is indexed but nullable", dbName)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to index user ID column: %w", err)
+	}
+	return nil
+}
+
+// userIDColumn returns whether the user ID column of a user table is
+// nullable and its type, or sql.ErrNoRows if the table has none.
+func userIDColumn(dbName string) (nullable, columnType string, err error) {
+	err = MySQLClient.QueryRow(
+		"SELECT IS_NULLABLE, LOWER(COLUMN_TYPE) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = ? AND TABLE_NAME = 'users' AND COLUMN_NAME = ?",
+		dbName, schema.UserIDColumn,
+	).Scan(&nullable, &columnType)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", "", fmt.Errorf("failed to inspect user ID column: %w", err)
+	}
+	return nullable, columnType, err
+}
+
+// isMySQLError reports whether err is the MySQL error with the number.
+func isMySQLError(err error, number uint16) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == number
+}
@@ -0,0 +1,104 @@
+// handlers/admin.go
+package handlers
+
+import (
+	"auth-service/apikey"
+	"auth-service/db"
+	pb "auth-service/proto"
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"google.golang.org/grpc/codes"
+)
+
+// AdminServiceServer implements the AdminService, which manages tenants and
+// the service itself.
+type AdminServiceServer struct {
+	pb.UnimplementedAdminServiceServer
+}
+
+// adminKey is an admin API key as stored in the control plane. Only the
+// hash of its secret is kept.
+type adminKey struct {
+	ID        string     `bson:"_id"`
+	Name      string     `bson:"name"`
+	Hash      string     `bson:"hash"`
+	CreatedAt time.Time  `bson:"created_at"`
+	CreatedBy string     `bson:"created_by"`
+	RevokedAt *time.Time `bson:"revoked_at"`
+}
+
+// CreateAdminKey issues an admin API key. The key is returned only once.
+func (s *AdminServiceServer) CreateAdminKey(ctx context.Context, req *pb.CreateAdminKeyRequest) (*pb.CreateAdminKeyResponse, error) {
+	if req.Name == "" {
+		return nil, requiredError("name")
+	}
+	key, id, hash, err := apikey.Generate(apikey.AdminPrefix)
+	if err != nil {
+		return nil, err
+	}
+	stored := adminKey{
+		ID:        id,
+		Name:      req.Name,
+		Hash:      hash,
+		CreatedAt: time.Now().UTC(),
+		CreatedBy: adminFromContext(ctx),
+	}
+	if _, err := db.GetAdminKeysCollection().InsertOne(ctx, stored); err != nil {
+		return nil, fmt.Errorf("failed to store admin key: %w", err)
+	}
+	return &pb.CreateAdminKeyResponse{
+		Message: "Admin key created successfully",
+		KeyId:   id,
+		ApiKey:  key,
+	}, nil
+}
+
+// ListAdminKeys lists all admin keys, including revoked ones, without their
+// secrets.
+func (s *AdminServiceServer) ListAdminKeys(ctx context.Context, req *pb.ListAdminKeysRequest) (*pb.ListAdminKeysResponse, error) {
+	cursor, err := db.GetAdminKeysCollection().Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"created_at": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list admin keys: %w", err)
+	}
+	var stored []adminKey
+	if err := cursor.All(ctx, &stored); err != nil {
+		return nil, fmt.Errorf("failed to list admin keys: %w", err)
+	}
+
+	keys := make([]*pb.AdminKey, 0, len(stored))
+	for _, key := range stored {
+		entry := &pb.AdminKey{
+			KeyId:     key.ID,
+			Name:      key.Name,
+			CreatedAt: key.CreatedAt.Unix(),
+			CreatedBy: key.CreatedBy,
+		}
+		if key.RevokedAt != nil {
+			entry.RevokedAt = key.RevokedAt.Unix()
+		}
+		keys = append(keys, entry)
+	}
+	return &pb.ListAdminKeysResponse{Keys: keys}, nil
+}
+
+// RevokeAdminKey disables an admin key for good.
+func (s *AdminServiceServer) RevokeAdminKey(ctx context.Context, req *pb.RevokeAdminKeyRequest) (*pb.RevokeAdminKeyResponse, error) {
+	if req.KeyId == "" {
+		return nil, requiredError("key_id")
+	}
+	result, err := db.GetAdminKeysCollection().UpdateOne(ctx,
+		bson.M{"_id": req.KeyId, "revoked_at": nil},
+		bson.M{"$set": bson.M{"revoked_at": time.Now().UTC()}},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to revoke admin key: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return nil, newError(codes.NotFound, ReasonNotFound, "admin key not found or already revoked")
+	}
+	return &pb.RevokeAdminKeyResponse{Message: "Admin key revoked successfully"}, nil
+}
@@ -0,0 +1,210 @@
+// handlers/apikeys.go
+package handlers
+
+import (
+	"auth-service/apikey"
+	"auth-service/db"
+	pb "auth-service/proto"
+	"auth-service/schema"
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"google.golang.org/grpc/codes"
+)
+
+// scopes are the scopes a tenant API key may be restricted to.
+var scopes = map[string]bool{
+	ScopeSignup:   true,
+	ScopeLogin:    true,
+	ScopeTokens:   true,
+	ScopeSessions: true,
+}
+
+// apiKey is a tenant API key as stored in the control plane. Only the hash
+// of its secret is kept.
+type apiKey struct {
+	ID        string     `bson:"_id"`
+	ClientID  string     `bson:"client_id"`
+	Name      string     `bson:"name"`
+	Scopes    []string   `bson:"scopes"`
+	Hash      string     `bson:"hash"`
+	CreatedAt time.Time  `bson:"created_at"`
+	RotatedAt *time.Time `bson:"rotated_at"`
+	RevokedAt *time.Time `bson:"revoked_at"`
+}
+
+// allows reports whether the key may call RPCs of the scope. A key without
+// scopes may call every tenant RPC.
+func (k apiKey) allows(scope string) bool {
+	if len(k.Scopes) == 0 {
+		return true
+	}
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// RotateClientSecret replaces the secret of a client that is in use. The
+// previous secret stops working immediately.
+func (s *AuthServiceServer) RotateClientSecret(ctx context.Context, req *pb.RotateClientSecretRequest) (*pb.RotateClientSecretResponse, error) {
+	c, err := loadClient(ctx, req.ClientId)
+	if err != nil {
+		return nil, err
+	}
+	secret, stored, err := newClientSecret()
+	if err != nil {
+		return nil, err
+	}
+	// A client deleted in the meantime keeps its secret
+	result, err := db.GetClientsCollection().UpdateOne(ctx,
+		bson.M{"_id": c.ID, "status": bson.M{"$in": bson.A{statusReady, nil}}},
+		bson.M{"$set": bson.M{"client_secret": stored}},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store client secret: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return nil, newError(codes.FailedPrecondition, ReasonClientNotReady, "client is not in use")
+	}
+	return &pb.RotateClientSecretResponse{
+		Message:      "Client secret rotated successfully",
+		ClientSecret: secret,
+	}, nil
+}
+
+// CreateApiKey issues an API key for a client, optionally restricted to some
+// scopes. The key is returned only once.
+func (s *AuthServiceServer) CreateApiKey(ctx context.Context, req *pb.CreateApiKeyRequest) (*pb.CreateApiKeyResponse, error) {
+	if _, err := loadClient(ctx, req.ClientId); err != nil {
+		return nil, err
+	}
+	if req.Name == "" {
+		return nil, requiredError("name")
+	}
+	var violations []schema.Violation
+	for i, scope := range req.Scopes {
+		if !scopes[scope] {
+			violations = append(violations, schema.Violation{
+				Field:       fmt.Sprintf("scopes[%d]", i),
+				Description: fmt.Sprintf("unknown scope %q", scope),
+			})
+		}
+	}
+	if len(violations) > 0 {
+		return nil, badRequest(ReasonInvalidRequest, "invalid request", "scopes", violations)
+	}
+
+	key, id, hash, err := apikey.Generate(apikey.TenantPrefix)
+	if err != nil {
+		return nil, err
+	}
+	stored := apiKey{
+		ID:        id,
+		ClientID:  req.ClientId,
+		Name:      req.Name,
+		Scopes:    req.Scopes,
+		Hash:      hash,
+		CreatedAt: time.Now().UTC(),
+	}
+	if _, err := db.GetAPIKeysCollection().InsertOne(ctx, stored); err != nil {
+		return nil, fmt.Errorf("failed to store API key: %w", err)
+	}
+	return &pb.CreateApiKeyResponse{
+		Message: "API key created successfully",
+		KeyId:   id,
+		ApiKey:  key,
+	}, nil
+}
+
+// ListApiKeys lists the API keys of a client, including revoked ones,
+// without their secrets.
+func (s *AuthServiceServer) ListApiKeys(ctx context.Context, req *pb.ListApiKeysRequest) (*pb.ListApiKeysResponse, error) {
+	if _, err := parseClientID(req.ClientId); err != nil {
+		return nil, err
+	}
+	cursor, err := db.GetAPIKeysCollection().Find(ctx,
+		bson.M{"client_id": req.ClientId},
+		options.Find().SetSort(bson.M{"created_at": 1}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+	var stored []apiKey
+	if err := cursor.All(ctx, &stored); err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+
+	keys := make([]*pb.ApiKey, 0, len(stored))
+	for _, key := range stored {
+		entry := &pb.ApiKey{
+			KeyId:     key.ID,
+			Name:      key.Name,
+			Scopes:    key.Scopes,
+			CreatedAt: key.CreatedAt.Unix(),
+		}
+		if key.RotatedAt != nil {
+			entry.RotatedAt = key.RotatedAt.Unix()
+		}
+		if key.RevokedAt != nil {
+			entry.RevokedAt = key.RevokedAt.Unix()
+		}
+		keys = append(keys, entry)
+	}
+	return &pb.ListApiKeysResponse{Keys: keys}, nil
+}
+
+// RotateApiKey replaces the secret of an API key, keeping its ID and scopes.
+// The previous key stops working immediately.
+func (s *AuthServiceServer) RotateApiKey(ctx context.Context, req *pb.RotateApiKeyRequest) (*pb.RotateApiKeyResponse, error) {
+	if _, err := parseClientID(req.ClientId); err != nil {
+		return nil, err
+	}
+	if req.KeyId == "" {
+		return nil, requiredError("key_id")
+	}
+	key, hash, err := apikey.Regenerate(apikey.TenantPrefix, req.KeyId)
+	if err != nil {
+		return nil, err
+	}
+	result, err := db.GetAPIKeysCollection().UpdateOne(ctx,
+		bson.M{"_id": req.KeyId, "client_id": req.ClientId, "revoked_at": nil},
+		bson.M{"$set": bson.M{"hash": hash, "rotated_at": time.Now().UTC()}},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate API key: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return nil, newError(codes.NotFound, ReasonNotFound, "API key not found or revoked")
+	}
+	return &pb.RotateApiKeyResponse{
+		Message: "API key rotated successfully",
+		ApiKey:  key,
+	}, nil
+}
+
+// RevokeApiKey disables an API key for good.
+func (s *AuthServiceServer) RevokeApiKey(ctx context.Context, req *pb.RevokeApiKeyRequest) (*pb.RevokeApiKeyResponse, error) {
+	if _, err := parseClientID(req.ClientId); err != nil {
+		return nil, err
+	}
+	if req.KeyId == "" {
+		return nil, requiredError("key_id")
+	}
+	result, err := db.GetAPIKeysCollection().UpdateOne(ctx,
+		bson.M{"_id": req.KeyId, "client_id": req.ClientId, "revoked_at": nil},
+		bson.M{"$set": bson.M{"revoked_at": time.Now().UTC()}},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to revoke API key: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return nil, newError(codes.NotFound, ReasonNotFound, "API key not found or already revoked")
+	}
+	return &pb.RevokeApiKeyResponse{Message: "API key revoked successfully"}, nil
+}
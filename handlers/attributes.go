@@ -0,0 +1,93 @@
+// handlers/attributes.go
+package handlers
+
+import (
+	"auth-service/schema"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// maxExactInteger is the largest integer a double, and therefore a
+// protobuf Struct number, holds exactly.
+const maxExactInteger = 1 << 53
+
+// columnText returns a scanned column value as MySQL renders it as text, or
+// false for NULL. Drivers return text as bytes, numbers of prepared
+// statements as numbers and, with parseTime, dates as time.Time.
+func columnText(f schema.Field, value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case nil:
+		return "", false
+	case []byte:
+		return string(v), true
+	case string:
+		return v, true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32), true
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), true
+	case time.Time:
+		if f.Type == schema.Date {
+			return v.Format("2006-01-02"), true
+		}
+		return v.Format("2006-01-02 15:04:05.999999"), true
+	}
+	return fmt.Sprint(value), true
+}
+
+// userAttribute converts a scanned column value into a value of the field's
+// logical type. NULL stays null.
+func userAttribute(f schema.Field, value interface{}) (*structpb.Value, error) {
+	if t, ok := value.(time.Time); ok && f.Type == schema.Timestamp {
+		return structpb.NewStringValue(t.Format(time.RFC3339Nano)), nil
+	}
+	raw, ok := columnText(f, value)
+	if !ok {
+		return structpb.NewNullValue(), nil
+	}
+
+	switch f.Type {
+	case schema.Int:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer in %s: %w", f.Name, err)
+		}
+		// Larger integers would be rounded, so they are returned as text
+		if n > maxExactInteger || n < -maxExactInteger {
+			return structpb.NewStringValue(raw), nil
+		}
+		return structpb.NewNumberValue(float64(n)), nil
+	case schema.Float:
+		x, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number in %s: %w", f.Name, err)
+		}
+		return structpb.NewNumberValue(x), nil
+	case schema.Bool:
+		// BOOLEAN is TINYINT(1), read as 0 or 1
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid boolean in %s: %w", f.Name, err)
+		}
+		return structpb.NewBoolValue(n != 0), nil
+	case schema.Timestamp:
+		t, err := time.Parse("2006-01-02 15:04:05.999999", raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp in %s: %w", f.Name, err)
+		}
+		return structpb.NewStringValue(t.Format(time.RFC3339Nano)), nil
+	case schema.JSON:
+		var v interface{}
+		if err := json.Unmarshal([]byte(raw), &v); err != nil {
+			return nil, fmt.Errorf("invalid JSON in %s: %w", f.Name, err)
+		}
+		return structpb.NewValue(v)
+	}
+	return structpb.NewStringValue(raw), nil
+}
@@ -0,0 +1,202 @@
+// handlers/auth.go
+package handlers
+
+import (
+	"auth-service/apikey"
+	"auth-service/db"
+	pb "auth-service/proto"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+// Metadata keys carrying credentials.
+const (
+	AdminKeyHeader     = "x-admin-key"
+	ClientSecretHeader = "x-client-secret"
+	APIKeyHeader       = "x-api-key"
+)
+
+// BootstrapAdmin is the principal of requests made with the bootstrap key.
+const BootstrapAdmin = "bootstrap"
+
+// Scopes of tenant API keys. A key without scopes may call every tenant RPC.
+const (
+	ScopeSignup   = "signup"
+	ScopeLogin    = "login"
+	ScopeTokens   = "tokens"
+	ScopeSessions = "sessions"
+)
+
+// adminService prefixes the methods of the AdminService, all of which
+// require admin credentials.
+var adminService = "/" + pb.AdminService_ServiceDesc.ServiceName + "/"
+
+// ownerMethods manage a client's credentials. They take the client secret
+// or an admin key, never an API key.
+var ownerMethods = map[string]bool{
+	pb.AuthService_RotateClientSecret_FullMethodName: true,
+	pb.AuthService_CreateApiKey_FullMethodName:       true,
+	pb.AuthService_ListApiKeys_FullMethodName:        true,
+	pb.AuthService_RotateApiKey_FullMethodName:       true,
+	pb.AuthService_RevokeApiKey_FullMethodName:       true,
+}
+
+// tenantMethods act on the users of a client and map to the scope an API
+// key needs for them. RPCs in none of these maps, such as GetJWKS, are
+// public.
+var tenantMethods = map[string]string{
+	pb.AuthService_Signup_FullMethodName:            ScopeSignup,
+	pb.AuthService_Login_FullMethodName:             ScopeLogin,
+	pb.AuthService_RefreshToken_FullMethodName:      ScopeTokens,
+	pb.AuthService_ValidateToken_FullMethodName:     ScopeTokens,
+	pb.AuthService_IntrospectToken_FullMethodName:   ScopeTokens,
+	pb.AuthService_Logout_FullMethodName:            ScopeTokens,
+	pb.AuthService_RevokeAllSessions_FullMethodName: ScopeSessions,
+}
+
+// bootstrapKeyHash is the SHA-256 digest of the bootstrap admin key, which
+// is configured out of band to create the first admin keys.
+var bootstrapKeyHash []byte
+
+// SetBootstrapAdminKey configures the bootstrap admin key. An empty key
+// disables it.
+func SetBootstrapAdminKey(key string) {
+	if key == "" {
+		bootstrapKeyHash = nil
+		return
+	}
+	sum := sha256.Sum256([]byte(key))
+	bootstrapKeyHash = sum[:]
+}
+
+type adminContextKey struct{}
+
+// adminFromContext returns the admin that made the request, or an empty
+// string.
+func adminFromContext(ctx context.Context) string {
+	admin, _ := ctx.Value(adminContextKey{}).(string)
+	return admin
+}
+
+// AuthInterceptor requires admin credentials for the AdminService and client
+// credentials for AuthService RPCs acting on a client's users. Admins may call
+// every RPC.
+func AuthInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	scope, tenant := tenantMethods[info.FullMethod]
+	owner := ownerMethods[info.FullMethod]
+	adminOnly := strings.HasPrefix(info.FullMethod, adminService)
+	if !adminOnly && !tenant && !owner {
+		return handler(ctx, req)
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	if len(md.Get(AdminKeyHeader)) > 0 || adminOnly {
+		admin, err := authenticateAdmin(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return handler(context.WithValue(ctx, adminContextKey{}, admin), req)
+	}
+
+	// Every tenant and owner request names its client
+	r, ok := req.(interface{ GetClientId() string })
+	if !ok {
+		return nil, newError(codes.Internal, ReasonInternal, "internal error")
+	}
+	if err := authenticateClient(ctx, r.GetClientId(), scope, owner); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// authenticateClient checks the client secret or, unless owner credentials
+// are required, an API key of the client with the scope.
+func authenticateClient(ctx context.Context, clientID, scope string, owner bool) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	secrets, keys := md.Get(ClientSecretHeader), md.Get(APIKeyHeader)
+	switch {
+	case len(secrets) == 1 && secrets[0] != "":
+		c, err := loadClient(ctx, clientID)
+		if err != nil {
+			return err
+		}
+		_, secret, ok := apikey.Parse(apikey.ClientSecretPrefix, secrets[0])
+		if !ok || c.ClientSecret == nil || !apikey.Matches(secret, c.ClientSecret.Hash) {
+			return newError(codes.Unauthenticated, ReasonClientCredentialsRequired, "invalid client credentials")
+		}
+		return nil
+	case len(keys) == 1 && keys[0] != "" && !owner:
+		if _, err := parseClientID(clientID); err != nil {
+			return err
+		}
+		id, secret, ok := apikey.Parse(apikey.TenantPrefix, keys[0])
+		if !ok {
+			return newError(codes.Unauthenticated, ReasonClientCredentialsRequired, "invalid client credentials")
+		}
+		var stored apiKey
+		err := db.GetAPIKeysCollection().FindOne(ctx, bson.M{"_id": id, "client_id": clientID, "revoked_at": nil}).Decode(&stored)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return newError(codes.Unauthenticated, ReasonClientCredentialsRequired, "invalid client credentials")
+		}
+		if err != nil {
+			return fmt.Errorf("failed to look up API key: %w", err)
+		}
+		if !apikey.Matches(secret, stored.Hash) {
+			return newError(codes.Unauthenticated, ReasonClientCredentialsRequired, "invalid client credentials")
+		}
+		if !stored.allows(scope) {
+			return newError(codes.PermissionDenied, ReasonScopeDenied, "API key lacks the %s scope", scope)
+		}
+		// Rejects clients pending deletion
+		_, err = loadClient(ctx, clientID)
+		return err
+	case owner:
+		return newError(codes.Unauthenticated, ReasonClientCredentialsRequired, "client secret required")
+	}
+	return newError(codes.Unauthenticated, ReasonClientCredentialsRequired, "client secret or API key required")
+}
+
+// authenticateAdmin checks the admin key in the request metadata and returns
+// the ID of the key, or BootstrapAdmin.
+func authenticateAdmin(ctx context.Context) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	keys := md.Get(AdminKeyHeader)
+	if len(keys) != 1 || keys[0] == "" {
+		return "", newError(codes.Unauthenticated, ReasonAdminRequired, "admin credentials required")
+	}
+	key := keys[0]
+
+	if bootstrapKeyHash != nil {
+		sum := sha256.Sum256([]byte(key))
+		if subtle.ConstantTimeCompare(sum[:], bootstrapKeyHash) == 1 {
+			return BootstrapAdmin, nil
+		}
+	}
+
+	id, secret, ok := apikey.Parse(apikey.AdminPrefix, key)
+	if !ok {
+		return "", newError(codes.Unauthenticated, ReasonAdminRequired, "invalid admin credentials")
+	}
+	var stored adminKey
+	err := db.GetAdminKeysCollection().FindOne(ctx, bson.M{"_id": id, "revoked_at": nil}).Decode(&stored)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "", newError(codes.Unauthenticated, ReasonAdminRequired, "invalid admin credentials")
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up admin key: %w", err)
+	}
+	if !apikey.Matches(secret, stored.Hash) {
+		return "", newError(codes.Unauthenticated, ReasonAdminRequired, "invalid admin credentials")
+	}
+	return stored.ID, nil
+}
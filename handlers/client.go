@@ -0,0 +1,166 @@
+// handlers/client.go
+package handlers
+
+import (
+	"auth-service/apikey"
+	"auth-service/db"
+	"auth-service/schema"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"google.golang.org/grpc/codes"
+)
+
+// client is the configuration of a client as stored on its document.
+type client struct {
+	ID              primitive.ObjectID `bson:"_id"`
+	Name            string             `bson:"name"`
+	Phone           string             `bson:"phone"`
+	Email           string             `bson:"email"`
+	OwnerID         string             `bson:"owner_id"`
+	Fields          []schema.Field     `bson:"fields"`
+	UserSchema      map[string]string  `bson:"user_schema"`
+	PrimaryKeyField string             `bson:"primary_key_field"`
+	ProfileFields   []string           `bson:"profile_fields"`
+	ClientSecret    *clientSecret      `bson:"client_secret"`
+	SigningKey      bson.Raw           `bson:"signing_key,omitempty"`
+	Status          string             `bson:"status"`
+	CreatedAt       time.Time          `bson:"created_at"`
+	PurgeAfter      *time.Time         `bson:"purge_after"`
+	Provisioning    *provisioning      `bson:"provisioning"`
+	SchemaVersion   int                `bson:"schema_version"`
+	Migration       *migrationLock     `bson:"migration"`
+}
+
+// Client statuses as stored. Clients registered before statuses existed
+// have none and are ready.
+const (
+	statusProvisioning    = "PROVISIONING"
+	statusReady           = "READY"
+	statusFailed          = "FAILED"
+	statusPendingDeletion = "PENDING_DELETION"
+	statusPurging         = "PURGING"
+)
+
+// clientSecret is the hash of a client's secret.
+type clientSecret struct {
+	ID        string    `bson:"id"`
+	Hash      string    `bson:"hash"`
+	CreatedAt time.Time `bson:"created_at"`
+}
+
+// newClientSecret generates a client secret and the record to store.
+func newClientSecret() (string, *clientSecret, error) {
+	secret, id, hash, err := apikey.Generate(apikey.ClientSecretPrefix)
+	if err != nil {
+		return "", nil, err
+	}
+	return secret, &clientSecret{ID: id, Hash: hash, CreatedAt: time.Now().UTC()}, nil
+}
+
+// loadClient looks up the configuration of a client that is in use.
+func loadClient(ctx context.Context, clientID string) (*client, error) {
+	c, err := findClient(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+	switch c.Status {
+	case statusPendingDeletion, statusPurging:
+		return nil, newError(codes.FailedPrecondition, ReasonClientPendingDeletion, "client is pending deletion")
+	case statusProvisioning, statusFailed:
+		return nil, newError(codes.FailedPrecondition, ReasonClientNotReady, "client is still being provisioned")
+	}
+	return c, nil
+}
+
+// findClient looks up the configuration of a client in any status.
+func findClient(ctx context.Context, clientID string) (*client, error) {
+	objectID, err := parseClientID(clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	var c client
+	err = db.GetClientsCollection().FindOne(ctx, bson.M{"_id": objectID}).Decode(&c)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, newError(codes.NotFound, ReasonClientNotFound, "client not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find client: %w", err)
+	}
+
+	// Clients registered before typed schemas only have the legacy map
+	if len(c.Fields) == 0 {
+		c.Fields = schema.FromStored(c.UserSchema, c.PrimaryKeyField)
+	}
+	return &c, nil
+}
+
+// parseClientID checks that a client ID is an ObjectID. Client IDs end up in
+// database names, so this has to pass before any query uses one.
+func parseClientID(clientID string) (primitive.ObjectID, error) {
+	objectID, err := primitive.ObjectIDFromHex(clientID)
+	if err != nil {
+		return objectID, newError(codes.InvalidArgument, ReasonInvalidClientID, "invalid client ID")
+	}
+	return objectID, nil
+}
+
+// primaryKeyField returns the column identifying the client's users. A
+// field named in the request has to be that column.
+func (c *client) primaryKeyField(requested string) (string, error) {
+	if c.field(c.PrimaryKeyField) == nil {
+		return "", newError(codes.FailedPrecondition, ReasonClientMisconfigured, "client has no usable primary key field")
+	}
+	if requested != "" && requested != c.PrimaryKeyField {
+		e := newError(codes.InvalidArgument, ReasonPrimaryKeyMismatch, "primary_key_field does not match the client")
+		e.Violations = []schema.Violation{{Field: "primary_key_field", Description: fmt.Sprintf("must be %q for this client", c.PrimaryKeyField)}}
+		return "", e
+	}
+	return c.PrimaryKeyField, nil
+}
+
+// field returns the schema field with the given name, or nil.
+func (c *client) field(name string) *schema.Field {
+	for i := range c.Fields {
+		if c.Fields[i].Name == name {
+			return &c.Fields[i]
+		}
+	}
+	return nil
+}
+
+// profileFields returns the fields returned to logged in users: those the
+// client listed, or all of them. Sensitive and deprecated fields are never
+// included.
+func (c *client) profileFields() []schema.Field {
+	var fields []schema.Field
+	if len(c.ProfileFields) == 0 {
+		for _, f := range c.Fields {
+			if !f.Sensitive && !f.Deprecated {
+				fields = append(fields, f)
+			}
+		}
+		return fields
+	}
+	for _, name := range c.ProfileFields {
+		if f := c.field(name); f != nil && !f.Sensitive && !f.Deprecated {
+			fields = append(fields, *f)
+		}
+	}
+	return fields
+}
+
+// schemaVersion returns the version of the client's schema. Clients start
+// at version 1, which is not stored.
+func (c *client) schemaVersion() int {
+	if c.SchemaVersion == 0 {
+		return 1
+	}
+	return c.SchemaVersion
+}
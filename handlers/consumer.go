@@ -3,62 +3,166 @@ package handlers
 import (
 	"auth-service/db"
 	pb "auth-service/proto"
+	"auth-service/schema"
+	"auth-service/token"
 	"context"
 	"fmt"
+	"strings"
+	"time"
 
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// AuthServiceServer implements the AuthService, which serves end users and
+// the clients they sign in to.
 type AuthServiceServer struct {
 	pb.UnimplementedAuthServiceServer
 }
 
 // GenerateClientID generates a unique client ID for a new client.
-func (s *AuthServiceServer) GenerateClientID(ctx context.Context, req *pb.GenerateClientRequest) (*pb.GenerateClientResponse, error) {
+func (s *AdminServiceServer) GenerateClientID(ctx context.Context, req *pb.GenerateClientRequest) (*pb.GenerateClientResponse, error) {
 	collection := db.GetClientsCollection()
+
+	// The schema becomes DDL, so reject it before anything is written
+	fields, container, violations := clientSchema(req.Fields, req.Schema, req.PrimaryKeyField)
+	violations = append(violations, schema.ValidateProfileFields(fields, req.ProfileFields)...)
+	if len(violations) > 0 {
+		return nil, schemaError(container, violations)
+	}
+
+	email := normalizeEmail(req.Email)
+	if err := checkEmailAvailable(ctx, email, primitive.NilObjectID); err != nil {
+		return nil, err
+	}
+
+	// The ID is chosen up front so that a signing key can be bound to it
+	objectID := primitive.NewObjectID()
+	clientID := objectID.Hex()
+
+	now := time.Now().UTC()
 	client := map[string]interface{}{
+		"_id":               objectID,
 		"name":              req.Name,
 		"phone":             req.Phone,
-		"email":             req.Email,
-		"user_schema":       req.Schema,
+		"email":             email,
+		"fields":            fields,
 		"primary_key_field": req.PrimaryKeyField,
+		"profile_fields":    req.ProfileFields,
+		"status":            statusProvisioning,
+		"created_at":        now,
+		"provisioning":      provisioning{Attempts: 1, StartedAt: now},
+	}
+	if len(req.Schema) > 0 {
+		// Kept for readers of the legacy schema format
+		userSchema := map[string]string{}
+		for _, f := range fields {
+			userSchema[f.Name] = f.ColumnType()
+		}
+		client["user_schema"] = userSchema
 	}
-	res, err := collection.InsertOne(ctx, client)
+	if req.DedicatedSigningKey {
+		signingKey, err := token.NewTenantKey(clientID, req.SigningAlgorithm)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create signing key: %w", err)
+		}
+		client["signing_key"] = signingKey
+	}
+	secret, storedSecret, err := newClientSecret()
 	if err != nil {
-		return nil, fmt.Errorf("failed to insert client: %w", err)
+		return nil, err
 	}
+	client["client_secret"] = storedSecret
 
-	// Convert the MongoDB ObjectID to a string
-	clientID := res.InsertedID.(primitive.ObjectID).Hex()
-
-	err = db.CreateUserTable(clientID, req.Schema)
+	// The owner is resolved last, so that an owner created for the client
+	// only has to be removed again if the insert fails
+	ownerID, createdOwner, err := clientOwner(ctx, req.OwnerId, req.OwnerEmail, email)
+	if err != nil {
+		return nil, err
+	}
+	client["owner_id"] = ownerID
+	_, err = collection.InsertOne(ctx, client)
+	if err != nil && createdOwner {
+		removeOwner(ctx, ownerID)
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, errClientExists()
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to create user table: %w", err)
+		return nil, fmt.Errorf("failed to insert client: %w", err)
+	}
+
+	// The document is tracked as provisioning, so a failure here is rolled
+	// back and retried by ReconcileClients rather than left half-built. The
+	// secret is not returned with the error; once the client is READY, its
+	// admin gets one from RotateClientSecret.
+	if err := provisionClient(ctx, objectID, fields, 1); err != nil {
+		e := toError(err)
+		e.Metadata = map[string]string{"client_id": clientID, "owner_id": ownerID}
+		return nil, e
 	}
-	return &pb.GenerateClientResponse{ClientId: clientID, Message: "Client ID generated successfully"}, nil
+	return &pb.GenerateClientResponse{
+		ClientId:     clientID,
+		Message:      "Client ID generated successfully",
+		ClientSecret: secret,
+		OwnerId:      ownerID,
+	}, nil
 }
 
-// GetClientID retrieves the client ID using the provided email.
-func (s *AuthServiceServer) GetClientID(ctx context.Context, req *pb.GetClientRequest) (*pb.GetClientResponse, error) {
-	collection := db.GetClientsCollection()
+// ValidateSchema checks the schema of a client as GenerateClientID would and
+// returns the DDL that would create its database and tables, with warnings
+// about likely mistakes. Nothing is written.
+func (s *AdminServiceServer) ValidateSchema(ctx context.Context, req *pb.ValidateSchemaRequest) (*pb.ValidateSchemaResponse, error) {
+	fields, container, violations := clientSchema(req.Fields, req.Schema, req.PrimaryKeyField)
+	violations = append(violations, schema.ValidateProfileFields(fields, req.ProfileFields)...)
+	if len(violations) > 0 {
+		return nil, schemaError(container, violations)
+	}
 
-	// Search for the document by email
-	filter := bson.M{"email": req.Email}
-	var client bson.M
-	err := collection.FindOne(ctx, filter).Decode(&client)
-	if err != nil {
-		return nil, fmt.Errorf("failed to find client: %w", err)
+	resp := &pb.ValidateSchemaResponse{
+		Fields:     fieldSpecs(fields),
+		Statements: db.ClientStatements("<client_id>", fields),
 	}
+	for _, w := range schema.Warnings(fields, req.PrimaryKeyField) {
+		resp.Warnings = append(resp.Warnings, &pb.SchemaWarning{Field: w.Field, Code: w.Code, Description: w.Description})
+	}
+	return resp, nil
+}
 
-	// Extract the client ID
-	clientID, ok := client["_id"].(primitive.ObjectID)
-	if !ok {
-		return nil, fmt.Errorf("invalid client ID format")
+// clientSchema validates the schema of a new client, given either as typed
+// fields or in the legacy map format, and returns the name of the request
+// field it came from for error reporting.
+func clientSchema(specs []*pb.FieldSpec, legacy map[string]string, primaryKeyField string) ([]schema.Field, string, []schema.Violation) {
+	if len(specs) == 0 {
+		fields, violations := schema.FromMap(legacy, primaryKeyField)
+		return fields, "schema", violations
+	}
+	if len(legacy) > 0 {
+		return nil, "fields", []schema.Violation{{Field: "fields", Description: "set either fields or the legacy schema, not both"}}
 	}
 
-	return &pb.GetClientResponse{
-		ClientId: clientID.Hex(),
-		Message:  "Client ID retrieved successfully",
-	}, nil
+	fields, violations := schema.ValidateFields(schemaFields(specs), primaryKeyField)
+	return fields, "fields", violations
+}
+
+// schemaFields converts typed fields of a request, before validation.
+func schemaFields(specs []*pb.FieldSpec) []schema.Field {
+	fields := make([]schema.Field, 0, len(specs))
+	for _, spec := range specs {
+		f := schema.Field{
+			Name:       spec.Name,
+			MaxLength:  int(spec.MaxLength),
+			Required:   spec.Required,
+			Unique:     spec.Unique,
+			Indexed:    spec.Indexed,
+			Default:    spec.DefaultValue,
+			Sensitive:  spec.Sensitive,
+			Deprecated: spec.Deprecated,
+		}
+		if spec.Type != pb.FieldType_FIELD_TYPE_UNSPECIFIED {
+			f.Type = schema.Type(strings.TrimPrefix(spec.Type.String(), "FIELD_TYPE_"))
+		}
+		fields = append(fields, f)
+	}
+	return fields
 }
@@ -0,0 +1,224 @@
+// handlers/errors.go
+package handlers
+
+import (
+	"auth-service/db"
+	"auth-service/password"
+	"auth-service/schema"
+	"auth-service/token"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/go-sql-driver/mysql"
+	"go.mongodb.org/mongo-driver/mongo"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+// ErrorDomain is the domain of the ErrorInfo detail of every error.
+const ErrorDomain = "auth-service"
+
+// Reasons reported in the ErrorInfo detail. They are stable, so callers can
+// branch on them rather than on messages.
+const (
+	ReasonInvalidRequest            = "INVALID_REQUEST"
+	ReasonInvalidSchema             = "INVALID_SCHEMA"
+	ReasonInvalidUserData           = "INVALID_USER_DATA"
+	ReasonInvalidClientID           = "INVALID_CLIENT_ID"
+	ReasonClientNotFound            = "CLIENT_NOT_FOUND"
+	ReasonClientPendingDeletion     = "CLIENT_PENDING_DELETION"
+	ReasonClientNotReady            = "CLIENT_NOT_READY"
+	ReasonClientExists              = "CLIENT_ALREADY_EXISTS"
+	ReasonOwnerNotFound             = "OWNER_NOT_FOUND"
+	ReasonOwnerExists               = "OWNER_ALREADY_EXISTS"
+	ReasonDestructiveChange         = "DESTRUCTIVE_SCHEMA_CHANGE"
+	ReasonSchemaVersionConflict     = "SCHEMA_VERSION_CONFLICT"
+	ReasonSchemaMigrationFailed     = "SCHEMA_MIGRATION_FAILED"
+	ReasonPrimaryKeyMismatch        = "PRIMARY_KEY_FIELD_MISMATCH"
+	ReasonClientMisconfigured       = "CLIENT_MISCONFIGURED"
+	ReasonInvalidCredentials        = "INVALID_CREDENTIALS"
+	ReasonAdminRequired             = "ADMIN_CREDENTIALS_REQUIRED"
+	ReasonClientCredentialsRequired = "CLIENT_CREDENTIALS_REQUIRED"
+	ReasonScopeDenied               = "SCOPE_DENIED"
+	ReasonUserExists                = "USER_ALREADY_EXISTS"
+	ReasonInvalidToken              = "INVALID_TOKEN"
+	ReasonTokenReused               = "REFRESH_TOKEN_REUSED"
+	ReasonNotFound                  = "NOT_FOUND"
+	ReasonAlreadyExists             = "ALREADY_EXISTS"
+	ReasonNotConfigured             = "NOT_CONFIGURED"
+	ReasonUnavailable               = "UNAVAILABLE"
+	ReasonInternal                  = "INTERNAL"
+)
+
+// Error is an error meant for callers. It carries a gRPC code, a stable
+// reason and optionally field violations. The cause is only logged.
+type Error struct {
+	Code       codes.Code
+	Reason     string
+	Message    string
+	Metadata   map[string]string
+	Violations []schema.Violation
+	Err        error
+}
+
+func newError(code codes.Code, reason, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Reason: reason, Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// GRPCStatus returns the status sent to callers, without the cause.
+func (e *Error) GRPCStatus() *status.Status {
+	st := status.New(e.Code, e.Message)
+	details := []protoadapt.MessageV1{&errdetails.ErrorInfo{Reason: e.Reason, Domain: ErrorDomain, Metadata: e.Metadata}}
+	if len(e.Violations) > 0 {
+		badRequest := &errdetails.BadRequest{}
+		for _, v := range e.Violations {
+			badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       v.Field,
+				Description: v.Description,
+			})
+		}
+		details = append(details, badRequest)
+	}
+	for _, detail := range details {
+		if detailed, err := st.WithDetails(detail); err == nil {
+			st = detailed
+		}
+	}
+	return st
+}
+
+// withCause records the error that led to e.
+func (e *Error) withCause(err error) *Error {
+	e.Err = err
+	return e
+}
+
+// schemaError returns an InvalidArgument error listing every violation of a
+// client schema as a BadRequest field violation. Violations of single fields
+// are reported below container, the request field holding the schema.
+func schemaError(container string, violations []schema.Violation) error {
+	return badRequest(ReasonInvalidSchema, "invalid schema", container, violations)
+}
+
+// userDataError returns an InvalidArgument error listing every field of the
+// user data that does not match the client schema.
+func userDataError(violations []schema.Violation) error {
+	return badRequest(ReasonInvalidUserData, "invalid user data", "user_data", violations)
+}
+
+// requiredError reports a missing request field.
+func requiredError(field string) error {
+	return badRequest(ReasonInvalidRequest, "invalid request", field, []schema.Violation{{Field: field, Description: "field is required"}})
+}
+
+func badRequest(reason, message, container string, violations []schema.Violation) error {
+	prefixed := make([]schema.Violation, 0, len(violations))
+	for _, v := range violations {
+		if v.Field != container && v.Field != "primary_key_field" && v.Field != "profile_fields" {
+			v.Field = container + "." + v.Field
+		}
+		prefixed = append(prefixed, v)
+	}
+	e := newError(codes.InvalidArgument, reason, "%s: %d field violation(s)", message, len(violations))
+	e.Violations = prefixed
+	return e
+}
+
+// toError classifies any error returned by a handler as an Error. Errors
+// that are not recognized become Internal without their text, which may
+// contain SQL or connection details.
+func toError(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	var grpcErr interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &grpcErr) {
+		st := grpcErr.GRPCStatus()
+		return &Error{Code: st.Code(), Reason: reasonForCode(st.Code()), Message: st.Message(), Err: err}
+	}
+
+	var mysqlErr *mysql.MySQLError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		return newError(codes.Canceled, ReasonUnavailable, "request canceled").withCause(err)
+	case errors.Is(err, context.DeadlineExceeded):
+		return newError(codes.DeadlineExceeded, ReasonUnavailable, "deadline exceeded").withCause(err)
+	case errors.Is(err, token.ErrRefreshTokenReused):
+		return newError(codes.Unauthenticated, ReasonTokenReused, "refresh token reuse detected").withCause(err)
+	case errors.Is(err, token.ErrInvalidToken), errors.Is(err, token.ErrInvalidRefreshToken):
+		return newError(codes.Unauthenticated, ReasonInvalidToken, "invalid token").withCause(err)
+	case errors.Is(err, token.ErrUnsupportedAlgorithm):
+		return newError(codes.InvalidArgument, ReasonInvalidRequest, "unsupported signing algorithm").withCause(err)
+	case errors.Is(err, token.ErrNotConfigured), errors.Is(err, token.ErrNoKeyEncryptionKey):
+		return newError(codes.FailedPrecondition, ReasonNotConfigured, "signing keys are not configured").withCause(err)
+	case errors.Is(err, password.ErrUnknownFormat):
+		return newError(codes.FailedPrecondition, ReasonClientMisconfigured, "stored credentials have an unknown format").withCause(err)
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return newError(codes.NotFound, ReasonNotFound, "not found").withCause(err)
+	case mongo.IsDuplicateKeyError(err):
+		return newError(codes.AlreadyExists, ReasonAlreadyExists, "already exists").withCause(err)
+	case isDuplicateKey(err):
+		return newError(codes.AlreadyExists, ReasonAlreadyExists, "already exists").withCause(err)
+	case mongo.IsTimeout(err), mongo.IsNetworkError(err),
+		errors.Is(err, driver.ErrBadConn), errors.Is(err, mysql.ErrInvalidConn),
+		errors.As(err, &netErr):
+		return newError(codes.Unavailable, ReasonUnavailable, "service temporarily unavailable").withCause(err)
+	case errors.As(err, &mysqlErr) && mysqlErr.Number == 1040:
+		// Too many connections
+		return newError(codes.Unavailable, ReasonUnavailable, "service temporarily unavailable").withCause(err)
+	}
+	return newError(codes.Internal, ReasonInternal, "internal error").withCause(err)
+}
+
+func isDuplicateKey(err error) bool {
+	_, ok := db.DuplicateKey(err)
+	return ok
+}
+
+func reasonForCode(code codes.Code) string {
+	switch code {
+	case codes.InvalidArgument:
+		return ReasonInvalidRequest
+	case codes.NotFound:
+		return ReasonNotFound
+	case codes.AlreadyExists:
+		return ReasonAlreadyExists
+	case codes.Unavailable:
+		return ReasonUnavailable
+	}
+	return ReasonInternal
+}
+
+// ErrorInterceptor turns every error returned by a handler into a status
+// with an ErrorInfo detail. Causes are logged, never returned.
+func ErrorInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	resp, err := handler(ctx, req)
+	if err == nil {
+		return resp, nil
+	}
+	e := toError(err)
+	if e.Err != nil && e.Code != codes.InvalidArgument && e.Code != codes.Unauthenticated {
+		log.Printf("%s failed: %v", info.FullMethod, err)
+	}
+	return nil, e.GRPCStatus().Err()
+}
@@ -0,0 +1,72 @@
+// handlers/introspect.go
+package handlers
+
+import (
+	pb "auth-service/proto"
+	"auth-service/token"
+	"context"
+	"errors"
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+)
+
+// ValidateToken verifies a token issued for the client and returns its
+// claims. Invalid, expired and revoked tokens are rejected with an error.
+func (s *AuthServiceServer) ValidateToken(ctx context.Context, req *pb.ValidateTokenRequest) (*pb.ValidateTokenResponse, error) {
+	// Unknown clients and clients not in use have no token tables
+	if _, err := loadClient(ctx, req.ClientId); err != nil {
+		return nil, err
+	}
+	info, err := token.Validate(ctx, req.ClientId, req.Token)
+	if errors.Is(err, token.ErrInvalidToken) {
+		return nil, errInvalidToken()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to validate token: %w", err)
+	}
+	return &pb.ValidateTokenResponse{
+		Message: "Token is valid",
+		Claims:  introspection(info),
+	}, nil
+}
+
+// IntrospectToken reports whether a token is active following RFC 7662. An
+// invalid token is not an error; it is reported as inactive.
+func (s *AuthServiceServer) IntrospectToken(ctx context.Context, req *pb.IntrospectTokenRequest) (*pb.IntrospectTokenResponse, error) {
+	// Unknown clients and clients not in use have no token tables
+	if _, err := loadClient(ctx, req.ClientId); err != nil {
+		return nil, err
+	}
+	info, err := token.Validate(ctx, req.ClientId, req.Token)
+	if errors.Is(err, token.ErrInvalidToken) {
+		return &pb.IntrospectTokenResponse{Active: false}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to introspect token: %w", err)
+	}
+	return introspection(info), nil
+}
+
+// errInvalidToken does not tell why a token was rejected.
+func errInvalidToken() error {
+	return newError(codes.Unauthenticated, ReasonInvalidToken, "invalid token")
+}
+
+func introspection(info *token.Info) *pb.IntrospectTokenResponse {
+	resp := &pb.IntrospectTokenResponse{
+		Active:    true,
+		ClientId:  info.ClientID,
+		Username:  info.Subject,
+		TokenType: info.TokenType,
+		Exp:       info.ExpiresAt.Unix(),
+		Sub:       info.Subject,
+		Iss:       info.Issuer,
+		Jti:       info.ID,
+		Sid:       info.SessionID,
+	}
+	if !info.IssuedAt.IsZero() {
+		resp.Iat = info.IssuedAt.Unix()
+	}
+	return resp
+}
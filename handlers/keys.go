@@ -0,0 +1,71 @@
+// handlers/keys.go
+package handlers
+
+import (
+	pb "auth-service/proto"
+	"auth-service/schema"
+	"auth-service/token"
+	"context"
+	"fmt"
+	"time"
+)
+
+// GetJWKS lists the public keys that verify tokens issued by this service,
+// including keys about to sign and recently retired keys. For a client with
+// a signing key of its own only that key is listed.
+func (s *AuthServiceServer) GetJWKS(ctx context.Context, req *pb.GetJWKSRequest) (*pb.GetJWKSResponse, error) {
+	published := token.PublishedKeys()
+	if req.ClientId != "" {
+		if _, err := parseClientID(req.ClientId); err != nil {
+			return nil, err
+		}
+		own, err := token.ClientKey(ctx, req.ClientId)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up client signing key: %w", err)
+		}
+		if own != nil {
+			published = []token.PublishedKey{*own}
+		}
+	}
+	keys := make([]*pb.JsonWebKey, 0, len(published))
+	for _, key := range published {
+		jwk := key.JWK()
+		entry := &pb.JsonWebKey{
+			Kty:         jwk.Kty,
+			Kid:         jwk.Kid,
+			Use:         jwk.Use,
+			Alg:         jwk.Alg,
+			N:           jwk.N,
+			E:           jwk.E,
+			Crv:         jwk.Crv,
+			X:           jwk.X,
+			Y:           jwk.Y,
+			Status:      key.Status,
+			ActivatesAt: key.ActivatesAt.Unix(),
+		}
+		if !key.RetiredAt.IsZero() {
+			entry.RetiredAt = key.RetiredAt.Unix()
+		}
+		keys = append(keys, entry)
+	}
+	return &pb.GetJWKSResponse{Keys: keys}, nil
+}
+
+// RotateSigningKey introduces a new signing key. It is published immediately
+// and signs tokens once the grace period has passed.
+func (s *AdminServiceServer) RotateSigningKey(ctx context.Context, req *pb.RotateSigningKeyRequest) (*pb.RotateSigningKeyResponse, error) {
+	if req.GracePeriodSeconds < 0 {
+		return nil, badRequest(ReasonInvalidRequest, "invalid request", "grace_period_seconds", []schema.Violation{
+			{Field: "grace_period_seconds", Description: "must not be negative"},
+		})
+	}
+	key, err := token.RotateSigningKey(req.Algorithm, time.Duration(req.GracePeriodSeconds)*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate signing key: %w", err)
+	}
+	return &pb.RotateSigningKeyResponse{
+		Message:     "Signing key rotated successfully",
+		Kid:         key.ID,
+		ActivatesAt: key.ActivatesAt.Unix(),
+	}, nil
+}
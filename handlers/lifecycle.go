@@ -0,0 +1,356 @@
+// handlers/lifecycle.go
+package handlers
+
+import (
+	"auth-service/db"
+	pb "auth-service/proto"
+	"auth-service/schema"
+	"auth-service/token"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"google.golang.org/grpc/codes"
+)
+
+// Page sizes of ListClients.
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// deletionGrace is how long a deleted client can be restored before it is
+// purged.
+var deletionGrace = 72 * time.Hour
+
+// SetClientDeletionGrace sets how long deleted clients are kept.
+func SetClientDeletionGrace(grace time.Duration) {
+	deletionGrace = grace
+}
+
+// DescribeClient returns the configuration of a client in any status.
+func (s *AdminServiceServer) DescribeClient(ctx context.Context, req *pb.DescribeClientRequest) (*pb.DescribeClientResponse, error) {
+	c, err := findClient(ctx, req.ClientId)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.DescribeClientResponse{Client: c.proto()}, nil
+}
+
+// UpdateClient changes the contact details and settings of a client. The
+// schema is changed with UpdateClientSchema.
+func (s *AdminServiceServer) UpdateClient(ctx context.Context, req *pb.UpdateClientRequest) (*pb.UpdateClientResponse, error) {
+	c, err := loadClient(ctx, req.ClientId)
+	if err != nil {
+		return nil, err
+	}
+
+	set := bson.M{}
+	if req.Name != nil {
+		set["name"] = *req.Name
+	}
+	if req.Phone != nil {
+		set["phone"] = *req.Phone
+	}
+	if req.Email != nil {
+		email := normalizeEmail(*req.Email)
+		if err := checkEmailAvailable(ctx, email, c.ID); err != nil {
+			return nil, err
+		}
+		set["email"] = email
+	}
+	if req.OwnerId != nil {
+		if err := checkOwner(ctx, *req.OwnerId); err != nil {
+			return nil, err
+		}
+		set["owner_id"] = *req.OwnerId
+	}
+	if req.ProfileFields != nil {
+		names := req.ProfileFields.Names
+		if violations := schema.ValidateProfileFields(c.Fields, names); len(violations) > 0 {
+			return nil, badRequest(ReasonInvalidRequest, "invalid request", "profile_fields", violations)
+		}
+		set["profile_fields"] = names
+	}
+	if len(set) == 0 {
+		return nil, newError(codes.InvalidArgument, ReasonInvalidRequest, "no fields to update")
+	}
+
+	var updated client
+	err = db.GetClientsCollection().FindOneAndUpdate(ctx,
+		bson.M{"_id": c.ID, "status": bson.M{"$nin": bson.A{statusPendingDeletion, statusPurging}}},
+		bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, errClientExists()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update client: %w", err)
+	}
+	// The schema is unchanged, so keep the fields derived for legacy clients
+	updated.Fields = c.Fields
+	return &pb.UpdateClientResponse{Message: "Client updated successfully", Client: updated.proto()}, nil
+}
+
+// ListClients pages through the clients in the order they were registered.
+// The page token is the ID of the last client of the previous page.
+func (s *AdminServiceServer) ListClients(ctx context.Context, req *pb.ListClientsRequest) (*pb.ListClientsResponse, error) {
+	filter := bson.M{}
+	if req.NamePrefix != "" {
+		filter["name"] = bson.M{"$regex": "^" + regexp.QuoteMeta(req.NamePrefix)}
+	}
+	if req.Email != "" {
+		filter["email"] = normalizeEmail(req.Email)
+	}
+	switch req.Status {
+	case pb.ClientStatus_CLIENT_STATUS_UNSPECIFIED:
+	case pb.ClientStatus_CLIENT_STATUS_READY:
+		// Clients registered before statuses existed have none
+		filter["status"] = bson.M{"$in": bson.A{statusReady, nil}}
+	default:
+		filter["status"] = strings.TrimPrefix(req.Status.String(), "CLIENT_STATUS_")
+	}
+
+	clients, next, err := listClients(ctx, filter, req.PageSize, req.PageToken)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ListClientsResponse{Clients: clients, NextPageToken: next}, nil
+}
+
+// listClients returns a page of the clients matching filter and the token
+// of the next page, if any.
+func listClients(ctx context.Context, filter bson.M, size int32, pageToken string) ([]*pb.Client, string, error) {
+	pageSize := int64(size)
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+	if pageToken != "" {
+		after, err := primitive.ObjectIDFromHex(pageToken)
+		if err != nil {
+			return nil, "", badRequest(ReasonInvalidRequest, "invalid request", "page_token", []schema.Violation{{Field: "page_token", Description: "invalid page token"}})
+		}
+		filter["_id"] = bson.M{"$gt": after}
+	}
+
+	// One more than a page tells whether there is a next page
+	cursor, err := db.GetClientsCollection().Find(ctx, filter,
+		options.Find().SetSort(bson.M{"_id": 1}).SetLimit(pageSize+1),
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list clients: %w", err)
+	}
+	var clients []client
+	if err := cursor.All(ctx, &clients); err != nil {
+		return nil, "", fmt.Errorf("failed to list clients: %w", err)
+	}
+
+	var next string
+	if int64(len(clients)) > pageSize {
+		clients = clients[:pageSize]
+		next = clients[pageSize-1].ID.Hex()
+	}
+	out := make([]*pb.Client, 0, len(clients))
+	for i := range clients {
+		c := &clients[i]
+		if len(c.Fields) == 0 {
+			c.Fields = schema.FromStored(c.UserSchema, c.PrimaryKeyField)
+		}
+		out = append(out, c.proto())
+	}
+	return out, next, nil
+}
+
+// DeleteClient schedules a client for deletion. The client stops working
+// immediately and is purged with its users after the grace period, unless
+// it is restored first. The request has to repeat the client ID in confirm.
+func (s *AdminServiceServer) DeleteClient(ctx context.Context, req *pb.DeleteClientRequest) (*pb.DeleteClientResponse, error) {
+	c, err := findClient(ctx, req.ClientId)
+	if err != nil {
+		return nil, err
+	}
+	if req.Confirm != req.ClientId {
+		return nil, badRequest(ReasonInvalidRequest, "invalid request", "confirm", []schema.Violation{{Field: "confirm", Description: "must repeat client_id"}})
+	}
+	if c.Status == statusPendingDeletion || c.Status == statusPurging {
+		return nil, newError(codes.FailedPrecondition, ReasonClientPendingDeletion, "client is already pending deletion")
+	}
+
+	purgeAfter := time.Now().UTC().Add(deletionGrace)
+	result, err := db.GetClientsCollection().UpdateOne(ctx,
+		bson.M{"_id": c.ID, "status": bson.M{"$nin": bson.A{statusPendingDeletion, statusPurging}}},
+		bson.M{"$set": bson.M{"status": statusPendingDeletion, "purge_after": purgeAfter}},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete client: %w", err)
+	}
+	if result.ModifiedCount == 0 {
+		return nil, newError(codes.FailedPrecondition, ReasonClientPendingDeletion, "client is already pending deletion")
+	}
+	return &pb.DeleteClientResponse{
+		Message:    "Client scheduled for deletion",
+		PurgeAfter: purgeAfter.Unix(),
+	}, nil
+}
+
+// RestoreClient cancels the deletion of a client that has not been purged.
+// A client that was never provisioned is provisioned again.
+func (s *AdminServiceServer) RestoreClient(ctx context.Context, req *pb.RestoreClientRequest) (*pb.RestoreClientResponse, error) {
+	c, err := findClient(ctx, req.ClientId)
+	if err != nil {
+		return nil, err
+	}
+	notPending := newError(codes.NotFound, ReasonClientNotFound, "client not found or not pending deletion")
+	if c.Status != statusPendingDeletion {
+		return nil, notPending
+	}
+
+	set := bson.M{"status": statusReady}
+	if c.Provisioning == nil || c.Provisioning.LastError == "" {
+		// Skipped by UpgradeUserTables while it was deleted
+		if err := db.EnsureUserIDColumn(c.ID.Hex()); err != nil {
+			if errors.Is(err, db.ErrUserIDColumnConflict) {
+				return nil, newError(codes.FailedPrecondition, ReasonClientMisconfigured, "user table has a user_id column of its own").withCause(err)
+			}
+			return nil, fmt.Errorf("failed to add user IDs to client: %w", err)
+		}
+	} else {
+		set = bson.M{
+			"status":                       statusFailed,
+			"provisioning.attempts":        0,
+			"provisioning.next_attempt_at": time.Now().UTC(),
+		}
+	}
+	result, err := db.GetClientsCollection().UpdateOne(ctx,
+		bson.M{"_id": c.ID, "status": statusPendingDeletion},
+		bson.M{"$set": set, "$unset": bson.M{"purge_after": ""}},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore client: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return nil, notPending
+	}
+	if c, err = findClient(ctx, req.ClientId); err != nil {
+		return nil, err
+	}
+	return &pb.RestoreClientResponse{Message: "Client restored successfully", Client: c.proto()}, nil
+}
+
+// purgeTimeout is how long a purge may hold a client before it is retried,
+// e.g. because the server purging it stopped mid-way.
+const purgeTimeout = 10 * time.Minute
+
+// PurgeDeletedClients removes the clients whose grace period has passed:
+// their database, their API keys, their schema migrations and finally their
+// document. Each client is first claimed as PURGING, which RestoreClient
+// rejects, so a client is never restored half purged. A failed purge is
+// logged and retried once its claim times out.
+func PurgeDeletedClients(ctx context.Context) error {
+	for {
+		now := time.Now().UTC()
+		var c client
+		err := db.GetClientsCollection().FindOneAndUpdate(ctx,
+			bson.M{"$or": bson.A{
+				bson.M{"status": statusPendingDeletion, "purge_after": bson.M{"$lte": now}},
+				bson.M{"status": statusPurging, "purge_started_at": bson.M{"$lt": now.Add(-purgeTimeout)}},
+			}},
+			bson.M{"$set": bson.M{"status": statusPurging, "purge_started_at": now}},
+			options.FindOneAndUpdate().SetProjection(bson.M{"_id": 1}),
+		).Decode(&c)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to claim deleted client: %w", err)
+		}
+
+		if err := purgeClient(ctx, c.ID); err != nil {
+			log.Printf("Purging client %s failed: %v", c.ID.Hex(), err)
+			continue
+		}
+		log.Printf("Purged client %s", c.ID.Hex())
+	}
+}
+
+// purgeClient removes a client claimed by PurgeDeletedClients.
+func purgeClient(ctx context.Context, id primitive.ObjectID) error {
+	clientID := id.Hex()
+	if err := db.DropClientDatabase(clientID); err != nil {
+		return err
+	}
+	if _, err := db.GetAPIKeysCollection().DeleteMany(ctx, bson.M{"client_id": clientID}); err != nil {
+		return fmt.Errorf("failed to delete API keys: %w", err)
+	}
+	if _, err := db.GetSchemaMigrationsCollection().DeleteMany(ctx, bson.M{"client_id": clientID}); err != nil {
+		return fmt.Errorf("failed to delete schema migrations: %w", err)
+	}
+	if _, err := db.GetClientsCollection().DeleteOne(ctx, bson.M{"_id": id, "status": statusPurging}); err != nil {
+		return fmt.Errorf("failed to delete client: %w", err)
+	}
+	token.ForgetTenantKey(clientID)
+	return nil
+}
+
+// proto returns the client as returned to admins, without secrets.
+func (c *client) proto() *pb.Client {
+	out := &pb.Client{
+		ClientId:            c.ID.Hex(),
+		Name:                c.Name,
+		Phone:               c.Phone,
+		Email:               c.Email,
+		OwnerId:             c.OwnerID,
+		PrimaryKeyField:     c.PrimaryKeyField,
+		ProfileFields:       c.ProfileFields,
+		Status:              pb.ClientStatus_CLIENT_STATUS_READY,
+		CreatedAt:           c.CreatedAt.Unix(),
+		DedicatedSigningKey: len(c.SigningKey) > 0,
+		SchemaVersion:       int32(c.schemaVersion()),
+	}
+	// Clients registered before creation times were stored
+	if c.CreatedAt.IsZero() {
+		out.CreatedAt = c.ID.Timestamp().Unix()
+	}
+	if c.Status != "" {
+		out.Status = pb.ClientStatus(pb.ClientStatus_value["CLIENT_STATUS_"+c.Status])
+	}
+	if c.Provisioning != nil {
+		out.StatusDetail = c.Provisioning.LastError
+	}
+	if c.PurgeAfter != nil {
+		out.PurgeAfter = c.PurgeAfter.Unix()
+	}
+	out.Fields = fieldSpecs(c.Fields)
+	return out
+}
+
+// fieldSpecs converts schema fields for responses.
+func fieldSpecs(fields []schema.Field) []*pb.FieldSpec {
+	specs := make([]*pb.FieldSpec, 0, len(fields))
+	for _, f := range fields {
+		specs = append(specs, &pb.FieldSpec{
+			Name:         f.Name,
+			Type:         pb.FieldType(pb.FieldType_value["FIELD_TYPE_"+string(f.Type)]),
+			MaxLength:    int32(f.MaxLength),
+			Required:     f.Required,
+			Unique:       f.Unique,
+			Indexed:      f.Indexed,
+			DefaultValue: f.Default,
+			Sensitive:    f.Sensitive,
+			Deprecated:   f.Deprecated,
+		})
+	}
+	return specs
+}
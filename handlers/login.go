@@ -3,28 +3,63 @@ package handlers
 
 import (
 	"auth-service/db"
+	"auth-service/password"
 	pb "auth-service/proto"
+	"auth-service/schema"
+	"auth-service/token"
 	"context"
-	"database/sql"
 	"fmt"
+	"log"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
 func (s *AuthServiceServer) Login(ctx context.Context, req *pb.LoginRequest) (*pb.LoginResponse, error) {
+	// Users are identified by the field the client registered, whatever the
+	// request names
+	c, err := loadClient(ctx, req.ClientId)
+	if err != nil {
+		return nil, err
+	}
+	pkField, err := c.primaryKeyField(req.PrimaryKeyField)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.field(password.Field) == nil {
+		return nil, newError(codes.FailedPrecondition, ReasonClientMisconfigured, "client schema has no password field")
+	}
+
+	// Only the profile fields are read besides the credentials, so sensitive
+	// columns never leave the database
+	selected := []string{fmt.Sprintf("`%s`", schema.UserIDColumn), fmt.Sprintf("`%s`", password.Field)}
+	profile := map[string]schema.Field{}
+	for _, f := range c.profileFields() {
+		selected = append(selected, fmt.Sprintf("`%s`", f.Name))
+		profile[f.Name] = f
+	}
+
 	// Construct the database and table name
 	dbName := fmt.Sprintf("client_%s", req.ClientId)
 	tableName := "users"
-	query := fmt.Sprintf("SELECT * FROM %s.%s WHERE %s = ? AND password = ?", dbName, tableName, req.PrimaryKeyField)
+	query := fmt.Sprintf("SELECT %s FROM %s.%s WHERE `%s` = ?", strings.Join(selected, ", "), dbName, tableName, pkField)
 
 	// Query for user data
-	rows, err := db.MySQLClient.Query(query, req.PrimaryKeyValue, req.Password)
+	rows, err := db.MySQLClient.Query(query, req.PrimaryKeyValue)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute login query: %w", err)
 	}
 	defer rows.Close()
 
-	// Check if user exists
+	// Check if user exists. Unknown users cost as much as known ones, so
+	// that they cannot be told apart by the response time.
 	if !rows.Next() {
-		return nil, fmt.Errorf("invalid credentials")
+		password.VerifyDummy(req.Password)
+		return nil, errInvalidCredentials()
 	}
 
 	// Get columns and map the result into a map[string]string
@@ -36,23 +71,108 @@ func (s *AuthServiceServer) Login(ctx context.Context, req *pb.LoginRequest) (*p
 	userData := make(map[string]string)
 	values := make([]interface{}, len(columns))
 	for i := range values {
-		values[i] = new(sql.NullString)
+		values[i] = new(interface{})
 	}
 
 	// Scan the result into values and map it to userData
 	if err := rows.Scan(values...); err != nil {
 		return nil, fmt.Errorf("failed to scan user data: %w", err)
 	}
+	// The string map is kept for older callers; attributes carry the
+	// schema types and tell NULL apart from empty values
+	attributes := &structpb.Struct{Fields: map[string]*structpb.Value{}}
+	var userID, stored string
 	for i, col := range columns {
-		if val, ok := values[i].(*sql.NullString); ok && val.Valid {
-			userData[col] = val.String
-		} else {
-			userData[col] = ""
+		value := *values[i].(*interface{})
+		text, _ := columnText(profile[col], value)
+		switch col {
+		case schema.UserIDColumn:
+			userID = text
+			continue
+		case password.Field:
+			stored = text
+			continue
+		}
+		userData[col] = text
+		attribute, err := userAttribute(profile[col], value)
+		if err != nil {
+			// A value the column type should not hold, e.g. one stored
+			// before the field's type changed, is returned as it is
+			log.Printf("Returning attribute of client %s as text: %v", req.ClientId, err)
+			attribute = structpb.NewStringValue(text)
+		}
+		attributes.Fields[col] = attribute
+	}
+
+	// Check the password against the stored hash
+	ok, rehash, err := password.Check(req.Password, stored)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify password: %w", err)
+	}
+	if !ok {
+		return nil, errInvalidCredentials()
+	}
+
+	// Upgrade plaintext and outdated hashes while the password is at hand.
+	// A failed upgrade is retried on the next login, so it does not fail
+	// this one.
+	if rehash {
+		if err := upgradePassword(ctx, req, pkField, stored); err != nil {
+			log.Printf("Failed to upgrade password for client %s: %v", req.ClientId, err)
 		}
 	}
 
+	refreshToken, sessionID, err := token.IssueRefreshToken(ctx, req.ClientId, userID, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue refresh token: %w", err)
+	}
+	accessToken, expiresIn, err := token.IssueAccessToken(ctx, req.ClientId, userID, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue access token: %w", err)
+	}
+
 	return &pb.LoginResponse{
-		UserDetails: userData,
-		Message:     "Login successful",
+		UserId:         userID,
+		UserDetails:    userData,
+		UserAttributes: attributes,
+		Message:        "Login successful",
+		AccessToken:    accessToken,
+		TokenType:      token.Type,
+		ExpiresIn:      int64(expiresIn.Seconds()),
+		RefreshToken:   refreshToken,
 	}, nil
 }
+
+// errInvalidCredentials does not tell unknown users from wrong passwords.
+func errInvalidCredentials() error {
+	return newError(codes.Unauthenticated, ReasonInvalidCredentials, "invalid credentials")
+}
+
+// upgradePassword replaces the stored password of the logged in user with a
+// hash made under the current policy.
+func upgradePassword(ctx context.Context, req *pb.LoginRequest, pkField, stored string) error {
+	if err := db.EnsurePasswordColumn(req.ClientId); err != nil {
+		return err
+	}
+	if objectID, err := primitive.ObjectIDFromHex(req.ClientId); err == nil {
+		// Clients created from typed fields have no legacy schema to update
+		filter := bson.M{"_id": objectID, "user_schema." + password.Field: bson.M{"$exists": true}}
+		update := bson.M{"$set": bson.M{"user_schema." + password.Field: password.ColumnType}}
+		if _, err := db.GetClientsCollection().UpdateOne(ctx, filter, update); err != nil {
+			return fmt.Errorf("failed to update client schema: %w", err)
+		}
+	}
+
+	hash, err := password.Hash(req.Password)
+	if err != nil {
+		return err
+	}
+
+	// Only replace the value that was verified, in case it changed meanwhile
+	dbName := fmt.Sprintf("client_%s", req.ClientId)
+	query := fmt.Sprintf("UPDATE %s.users SET %s = ? WHERE `%s` = ? AND %s = ?", dbName, password.Field, pkField, password.Field)
+	if _, err := db.MySQLClient.ExecContext(ctx, query, hash, req.PrimaryKeyValue, stored); err != nil {
+		return fmt.Errorf("failed to store upgraded password: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+// handlers/logout.go
+package handlers
+
+import (
+	pb "auth-service/proto"
+	"auth-service/schema"
+	"auth-service/token"
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Logout ends the session of the presented refresh or access token. Tokens
+// that are already invalid are ignored, so logging out twice succeeds.
+func (s *AuthServiceServer) Logout(ctx context.Context, req *pb.LogoutRequest) (*pb.LogoutResponse, error) {
+	// Unknown clients and clients not in use have no token tables
+	if _, err := loadClient(ctx, req.ClientId); err != nil {
+		return nil, err
+	}
+	if req.RefreshToken == "" && req.AccessToken == "" {
+		return nil, badRequest(ReasonInvalidRequest, "invalid request", "refresh_token", []schema.Violation{
+			{Field: "refresh_token", Description: "refresh_token or access_token is required"},
+		})
+	}
+
+	if req.RefreshToken != "" {
+		err := token.RevokeRefreshToken(ctx, req.ClientId, req.RefreshToken)
+		if err != nil && !errors.Is(err, token.ErrInvalidRefreshToken) {
+			return nil, fmt.Errorf("failed to revoke refresh token: %w", err)
+		}
+	}
+
+	if req.AccessToken != "" {
+		info, err := token.Validate(ctx, req.ClientId, req.AccessToken)
+		switch {
+		case errors.Is(err, token.ErrInvalidToken):
+		case err != nil:
+			return nil, fmt.Errorf("failed to validate access token: %w", err)
+		case info.TokenType == token.AccessTokenType:
+			if err := token.RevokeAccessToken(ctx, req.ClientId, info); err != nil {
+				return nil, err
+			}
+			if info.SessionID != "" {
+				if err := token.RevokeSession(ctx, req.ClientId, info.SessionID); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return &pb.LogoutResponse{Message: "Logout successful"}, nil
+}
+
+// RevokeAllSessions cuts a user of a client off: every refresh token is
+// revoked and every access token issued so far fails validation.
+func (s *AuthServiceServer) RevokeAllSessions(ctx context.Context, req *pb.RevokeAllSessionsRequest) (*pb.RevokeAllSessionsResponse, error) {
+	// Unknown clients and clients not in use have no token tables
+	if _, err := loadClient(ctx, req.ClientId); err != nil {
+		return nil, err
+	}
+	if req.Subject == "" {
+		return nil, requiredError("subject")
+	}
+	revoked, err := token.RevokeAllSessions(ctx, req.ClientId, req.Subject)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.RevokeAllSessionsResponse{
+		Message:         "Sessions revoked successfully",
+		RevokedSessions: revoked,
+	}, nil
+}
@@ -0,0 +1,256 @@
+// handlers/migrations.go
+package handlers
+
+import (
+	"auth-service/db"
+	pb "auth-service/proto"
+	"auth-service/schema"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+)
+
+// migrationTimeout is how long a migration may hold a client's schema
+// before it is considered abandoned.
+const migrationTimeout = 10 * time.Minute
+
+// Statuses of schema migrations.
+const (
+	migrationRunning = "RUNNING"
+	migrationApplied = "APPLIED"
+	migrationFailed  = "FAILED"
+)
+
+// migrationLock marks a client whose schema is being migrated.
+type migrationLock struct {
+	ID        primitive.ObjectID `bson:"id"`
+	StartedAt time.Time          `bson:"started_at"`
+}
+
+// schemaMigration records a change of a client's schema.
+type schemaMigration struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	ClientID    string             `bson:"client_id"`
+	FromVersion int                `bson:"from_version"`
+	ToVersion   int                `bson:"to_version"`
+	Changes     []schema.Change    `bson:"changes"`
+	Fields      []schema.Field     `bson:"fields"`
+	Admin       string             `bson:"admin"`
+	Status      string             `bson:"status"`
+	Error       string             `bson:"error,omitempty"`
+	StartedAt   time.Time          `bson:"started_at"`
+	FinishedAt  *time.Time         `bson:"finished_at,omitempty"`
+}
+
+// UpdateClientSchema migrates the user table of a client to a new schema.
+// The changes are applied in one ALTER TABLE statement and recorded as a
+// migration that bumps the schema version. Destructive changes need
+// allow_destructive; a dry run only returns the changes.
+func (s *AdminServiceServer) UpdateClientSchema(ctx context.Context, req *pb.UpdateClientSchemaRequest) (*pb.UpdateClientSchemaResponse, error) {
+	c, err := loadClient(ctx, req.ClientId)
+	if err != nil {
+		return nil, err
+	}
+	if len(req.Fields) == 0 {
+		return nil, requiredError("fields")
+	}
+	fields, violations := schema.ValidateFields(schemaFields(req.Fields), c.PrimaryKeyField)
+	violations = append(violations, schema.ValidateProfileFields(fields, c.ProfileFields)...)
+	if len(violations) > 0 {
+		return nil, schemaError("fields", violations)
+	}
+
+	version := c.schemaVersion()
+	if req.ExpectedVersion != 0 && int(req.ExpectedVersion) != version {
+		return nil, errSchemaVersionConflict(version)
+	}
+	changes, violations := schema.Diff(c.Fields, fields)
+	if len(violations) > 0 {
+		return nil, schemaError("fields", violations)
+	}
+
+	clauses := schema.AlterTable(changes)
+	resp := &pb.UpdateClientSchemaResponse{FromVersion: int32(version), ToVersion: int32(version + 1)}
+	for _, change := range changes {
+		resp.Changes = append(resp.Changes, &pb.SchemaChange{
+			Kind:        string(change.Kind),
+			Field:       change.Field,
+			Description: change.Description,
+			Destructive: change.Destructive,
+			Clause:      change.Clause,
+		})
+	}
+	if len(clauses) > 0 {
+		resp.Statement = "ALTER TABLE users " + strings.Join(clauses, ", ")
+	}
+
+	switch {
+	case len(changes) == 0:
+		resp.Message = "Schema is up to date"
+		resp.ToVersion = resp.FromVersion
+		resp.Client = c.proto()
+		return resp, nil
+	case req.DryRun:
+		resp.Message = "Dry run, no changes applied"
+		resp.Client = c.proto()
+		return resp, nil
+	}
+	if !req.AllowDestructive {
+		var destructive []schema.Violation
+		for _, change := range changes {
+			if change.Destructive {
+				destructive = append(destructive, schema.Violation{Field: change.Field, Description: change.Description})
+			}
+		}
+		if len(destructive) > 0 {
+			e := newError(codes.FailedPrecondition, ReasonDestructiveChange, "schema changes may lose data, set allow_destructive to apply them")
+			e.Violations = destructive
+			return nil, e
+		}
+	}
+
+	m, err := startMigration(ctx, c, fields, changes)
+	if err != nil {
+		return nil, err
+	}
+	resp.MigrationId = m.ID.Hex()
+	if err := applyMigration(ctx, c, m, clauses); err != nil {
+		e := toError(err)
+		e.Metadata = map[string]string{"migration_id": m.ID.Hex()}
+		return nil, e
+	}
+
+	if c, err = findClient(ctx, req.ClientId); err != nil {
+		return nil, err
+	}
+	resp.Message = "Client schema updated successfully"
+	resp.Client = c.proto()
+	return resp, nil
+}
+
+// startMigration locks the client's schema at its current version and
+// records the migration. Migrations that hold the lock for longer than
+// migrationTimeout are taken over.
+func startMigration(ctx context.Context, c *client, fields []schema.Field, changes []schema.Change) (*schemaMigration, error) {
+	version := c.schemaVersion()
+	now := time.Now().UTC()
+	m := &schemaMigration{
+		ID:          primitive.NewObjectID(),
+		ClientID:    c.ID.Hex(),
+		FromVersion: version,
+		ToVersion:   version + 1,
+		Changes:     changes,
+		Fields:      fields,
+		Admin:       adminFromContext(ctx),
+		Status:      migrationRunning,
+		StartedAt:   now,
+	}
+
+	var storedVersion interface{} = version
+	if version == 1 {
+		storedVersion = bson.M{"$in": bson.A{nil, 1}}
+	}
+	result, err := db.GetClientsCollection().UpdateOne(ctx,
+		bson.M{
+			"_id":            c.ID,
+			"status":         bson.M{"$in": bson.A{statusReady, nil}},
+			"schema_version": storedVersion,
+			"$or": bson.A{
+				bson.M{"migration": nil},
+				bson.M{"migration.started_at": bson.M{"$lte": now.Add(-migrationTimeout)}},
+			},
+		},
+		bson.M{"$set": bson.M{"migration": migrationLock{ID: m.ID, StartedAt: now}}},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock client schema: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return nil, errSchemaVersionConflict(0)
+	}
+
+	if _, err := db.GetSchemaMigrationsCollection().InsertOne(ctx, m); err != nil {
+		releaseMigration(ctx, c.ID, m.ID)
+		return nil, fmt.Errorf("failed to record schema migration: %w", err)
+	}
+	return m, nil
+}
+
+// applyMigration alters the user table and stores the new schema. The
+// statement either applies completely or not at all, so a failed migration
+// leaves the table as it was.
+func applyMigration(ctx context.Context, c *client, m *schemaMigration, clauses []string) error {
+	if len(clauses) > 0 {
+		if err := db.AlterUserTable(m.ClientID, clauses); err != nil {
+			finishMigration(ctx, m, err)
+			releaseMigration(ctx, c.ID, m.ID)
+			if _, ok := db.DuplicateKey(err); ok {
+				e := newError(codes.FailedPrecondition, ReasonSchemaMigrationFailed, "existing users share a value of a field that would become unique")
+				e.Err = err
+				return e
+			}
+			return fmt.Errorf("failed to migrate schema of client %s: %w", m.ClientID, err)
+		}
+	}
+
+	set := bson.M{"fields": m.Fields, "schema_version": m.ToVersion}
+	if len(c.UserSchema) > 0 {
+		// Kept for readers of the legacy schema format
+		userSchema := map[string]string{}
+		for _, f := range m.Fields {
+			userSchema[f.Name] = f.ColumnType()
+		}
+		set["user_schema"] = userSchema
+	}
+	_, err := db.GetClientsCollection().UpdateOne(ctx,
+		bson.M{"_id": c.ID, "migration.id": m.ID},
+		bson.M{"$set": set, "$unset": bson.M{"migration": ""}},
+	)
+	if err != nil {
+		// The table has changed, so the record keeps the migration to be
+		// stored by hand
+		finishMigration(ctx, m, err)
+		return fmt.Errorf("failed to store schema of client %s: %w", m.ClientID, err)
+	}
+	finishMigration(ctx, m, nil)
+	return nil
+}
+
+// finishMigration records the outcome of a migration.
+func finishMigration(ctx context.Context, m *schemaMigration, migrationErr error) {
+	set := bson.M{"status": migrationApplied, "finished_at": time.Now().UTC()}
+	if migrationErr != nil {
+		set["status"], set["error"] = migrationFailed, migrationErr.Error()
+	}
+	if _, err := db.GetSchemaMigrationsCollection().UpdateOne(ctx, bson.M{"_id": m.ID}, bson.M{"$set": set}); err != nil {
+		log.Printf("Failed to record outcome of schema migration %s: %v", m.ID.Hex(), err)
+	}
+}
+
+// releaseMigration unlocks the client's schema if the migration holds it.
+func releaseMigration(ctx context.Context, clientID, migrationID primitive.ObjectID) {
+	_, err := db.GetClientsCollection().UpdateOne(ctx,
+		bson.M{"_id": clientID, "migration.id": migrationID},
+		bson.M{"$unset": bson.M{"migration": ""}},
+	)
+	if err != nil {
+		log.Printf("Failed to unlock schema of client %s: %v", clientID.Hex(), err)
+	}
+}
+
+// errSchemaVersionConflict reports a schema that changed since the caller
+// read it, or that is being migrated.
+func errSchemaVersionConflict(version int) error {
+	if version == 0 {
+		return newError(codes.Aborted, ReasonSchemaVersionConflict, "client schema is being migrated or has changed, retry with the current version")
+	}
+	e := newError(codes.Aborted, ReasonSchemaVersionConflict, "client schema is at version %d", version)
+	e.Metadata = map[string]string{"schema_version": fmt.Sprint(version)}
+	return e
+}
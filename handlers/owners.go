@@ -0,0 +1,261 @@
+// handlers/owners.go
+package handlers
+
+import (
+	"auth-service/db"
+	pb "auth-service/proto"
+	"auth-service/schema"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"google.golang.org/grpc/codes"
+)
+
+// owner is an account owning clients, identified by its email.
+type owner struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	Email     string             `bson:"email"`
+	Name      string             `bson:"name"`
+	CreatedAt time.Time          `bson:"created_at"`
+}
+
+func (o *owner) proto() *pb.Owner {
+	return &pb.Owner{
+		OwnerId:   o.ID.Hex(),
+		Email:     o.Email,
+		Name:      o.Name,
+		CreatedAt: o.CreatedAt.Unix(),
+	}
+}
+
+// normalizeEmail returns the form in which emails are stored and compared.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func errClientExists() error {
+	e := newError(codes.AlreadyExists, ReasonClientExists, "a client with this email already exists")
+	e.Metadata = map[string]string{"field": "email"}
+	return e
+}
+
+// checkEmailAvailable rejects an email used by a client other than except.
+// The unique index enforces this too, but is missing while existing clients
+// share an email.
+func checkEmailAvailable(ctx context.Context, email string, except primitive.ObjectID) error {
+	if email == "" {
+		return nil
+	}
+	err := db.GetClientsCollection().FindOne(ctx,
+		bson.M{"email": email, "_id": bson.M{"$ne": except}},
+		options.FindOne().SetProjection(bson.M{"_id": 1}),
+	).Err()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to look up client email: %w", err)
+	}
+	return errClientExists()
+}
+
+// CreateOwner creates an owner account.
+func (s *AdminServiceServer) CreateOwner(ctx context.Context, req *pb.CreateOwnerRequest) (*pb.CreateOwnerResponse, error) {
+	email := normalizeEmail(req.Email)
+	if email == "" {
+		return nil, requiredError("email")
+	}
+	o := owner{ID: primitive.NewObjectID(), Email: email, Name: req.Name, CreatedAt: time.Now().UTC()}
+	_, err := db.GetOwnersCollection().InsertOne(ctx, o)
+	if mongo.IsDuplicateKeyError(err) {
+		e := newError(codes.AlreadyExists, ReasonOwnerExists, "an owner with this email already exists")
+		e.Metadata = map[string]string{"field": "email"}
+		return nil, e
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert owner: %w", err)
+	}
+	return &pb.CreateOwnerResponse{Message: "Owner created successfully", Owner: o.proto()}, nil
+}
+
+// ListClientsForOwner pages through the clients of an owner, found by ID or
+// email.
+func (s *AdminServiceServer) ListClientsForOwner(ctx context.Context, req *pb.ListClientsForOwnerRequest) (*pb.ListClientsForOwnerResponse, error) {
+	var filter bson.M
+	switch {
+	case req.OwnerId != "" && req.OwnerEmail != "":
+		return nil, badRequest(ReasonInvalidRequest, "invalid request", "owner_id", []schema.Violation{{Field: "owner_id", Description: "set either owner_id or owner_email, not both"}})
+	case req.OwnerId != "":
+		id, err := primitive.ObjectIDFromHex(req.OwnerId)
+		if err != nil {
+			return nil, badRequest(ReasonInvalidRequest, "invalid request", "owner_id", []schema.Violation{{Field: "owner_id", Description: "invalid owner ID"}})
+		}
+		filter = bson.M{"_id": id}
+	case req.OwnerEmail != "":
+		filter = bson.M{"email": normalizeEmail(req.OwnerEmail)}
+	default:
+		return nil, requiredError("owner_id")
+	}
+
+	var o owner
+	err := db.GetOwnersCollection().FindOne(ctx, filter).Decode(&o)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, newError(codes.NotFound, ReasonOwnerNotFound, "owner not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find owner: %w", err)
+	}
+
+	clients, next, err := listClients(ctx, bson.M{"owner_id": o.ID.Hex()}, req.PageSize, req.PageToken)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ListClientsForOwnerResponse{Owner: o.proto(), Clients: clients, NextPageToken: next}, nil
+}
+
+// clientOwner returns the ID of the owner of a new client: the owner with
+// the given ID, or the owner with the given email, which defaults to the
+// client's email and is created if needed. Clients without any email have
+// no owner. created tells whether the owner was created for the client, to
+// be removed with removeOwner if the client cannot be stored.
+func clientOwner(ctx context.Context, ownerID, ownerEmail, clientEmail string) (id string, created bool, err error) {
+	if ownerID != "" {
+		if err := checkOwner(ctx, ownerID); err != nil {
+			return "", false, err
+		}
+		return ownerID, false, nil
+	}
+	email := normalizeEmail(ownerEmail)
+	if email == "" {
+		email = clientEmail
+	}
+	if email == "" {
+		return "", false, nil
+	}
+	o, created, err := ownerByEmail(ctx, email)
+	if err != nil {
+		return "", false, err
+	}
+	return o.ID.Hex(), created, nil
+}
+
+// removeOwner deletes an owner created for a client that could not be
+// stored, unless another client has been given it since.
+func removeOwner(ctx context.Context, ownerID string) {
+	id, err := primitive.ObjectIDFromHex(ownerID)
+	if err != nil {
+		return
+	}
+	err = db.GetClientsCollection().FindOne(ctx, bson.M{"owner_id": ownerID}).Err()
+	if err == nil {
+		return
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		log.Printf("Failed to remove owner %s: %v", ownerID, err)
+		return
+	}
+	if _, err := db.GetOwnersCollection().DeleteOne(ctx, bson.M{"_id": id}); err != nil {
+		log.Printf("Failed to remove owner %s: %v", ownerID, err)
+	}
+}
+
+// checkOwner rejects owner IDs that do not belong to an owner.
+func checkOwner(ctx context.Context, ownerID string) error {
+	id, err := primitive.ObjectIDFromHex(ownerID)
+	if err != nil {
+		return badRequest(ReasonInvalidRequest, "invalid request", "owner_id", []schema.Violation{{Field: "owner_id", Description: "invalid owner ID"}})
+	}
+	err = db.GetOwnersCollection().FindOne(ctx, bson.M{"_id": id}).Err()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return newError(codes.NotFound, ReasonOwnerNotFound, "owner not found")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to find owner: %w", err)
+	}
+	return nil
+}
+
+// ownerByEmail returns the owner with the email, creating it if needed, and
+// whether it was created.
+func ownerByEmail(ctx context.Context, email string) (*owner, bool, error) {
+	var o owner
+	newID := primitive.NewObjectID()
+	err := db.GetOwnersCollection().FindOneAndUpdate(ctx,
+		bson.M{"email": email},
+		bson.M{"$setOnInsert": bson.M{"_id": newID, "email": email, "created_at": time.Now().UTC()}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&o)
+	if mongo.IsDuplicateKeyError(err) {
+		// Created concurrently
+		err = db.GetOwnersCollection().FindOne(ctx, bson.M{"email": email}).Decode(&o)
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to find owner: %w", err)
+	}
+	return &o, o.ID == newID, nil
+}
+
+// NormalizeClientEmails stores the emails of clients registered before
+// emails were normalized in normalized form, so that the exact matches of
+// checkEmailAvailable and the unique index compare them case-insensitively.
+// It has to run before the index is built.
+func NormalizeClientEmails(ctx context.Context) error {
+	normalized := bson.M{"$toLower": bson.M{"$trim": bson.M{"input": "$email"}}}
+	result, err := db.GetClientsCollection().UpdateMany(ctx,
+		bson.M{"email": bson.M{"$type": "string"}, "$expr": bson.M{"$ne": bson.A{"$email", normalized}}},
+		bson.A{bson.M{"$set": bson.M{"email": normalized}}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to normalize client emails: %w", err)
+	}
+	if result.ModifiedCount > 0 {
+		log.Printf("Normalized the emails of %d clients", result.ModifiedCount)
+	}
+	return nil
+}
+
+// AssignOwners gives every client registered before owners existed the
+// owner with its normalized email, so that ListClientsForOwner finds it and
+// emails differing only in case share one owner.
+func AssignOwners(ctx context.Context) error {
+	cursor, err := db.GetClientsCollection().Find(ctx,
+		bson.M{"owner_id": bson.M{"$exists": false}, "email": bson.M{"$gt": ""}},
+		options.Find().SetProjection(bson.M{"_id": 1, "email": 1}),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to find clients without owner: %w", err)
+	}
+	var clients []struct {
+		ID    primitive.ObjectID `bson:"_id"`
+		Email string             `bson:"email"`
+	}
+	if err := cursor.All(ctx, &clients); err != nil {
+		return fmt.Errorf("failed to find clients without owner: %w", err)
+	}
+
+	for _, c := range clients {
+		o, _, err := ownerByEmail(ctx, normalizeEmail(c.Email))
+		if err != nil {
+			return err
+		}
+		_, err = db.GetClientsCollection().UpdateOne(ctx,
+			bson.M{"_id": c.ID, "owner_id": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"owner_id": o.ID.Hex()}},
+		)
+		if err != nil {
+			return fmt.Errorf("failed to assign owner to client %s: %w", c.ID.Hex(), err)
+		}
+	}
+	if len(clients) > 0 {
+		log.Printf("Assigned owners to %d clients", len(clients))
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+// handlers/provision.go
+package handlers
+
+import (
+	"auth-service/db"
+	"auth-service/schema"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// provisioningTimeout is how long an attempt may take before it is
+	// considered abandoned, e.g. by a server that stopped mid-way.
+	provisioningTimeout = 5 * time.Minute
+	// maxProvisioningAttempts is how often provisioning is tried before the
+	// client is scheduled for deletion.
+	maxProvisioningAttempts = 5
+)
+
+// provisioning tracks the creation of a client's database.
+type provisioning struct {
+	Attempts      int        `bson:"attempts"`
+	StartedAt     time.Time  `bson:"started_at"`
+	NextAttemptAt *time.Time `bson:"next_attempt_at"`
+	LastError     string     `bson:"last_error"`
+}
+
+// provisionClient creates the database of a client in PROVISIONING and
+// marks it READY. A failed attempt drops whatever part of the database was
+// created and marks the client FAILED, to be retried by ReconcileClients.
+func provisionClient(ctx context.Context, id primitive.ObjectID, fields []schema.Field, attempts int) error {
+	clientID := id.Hex()
+	err := db.CreateUserTable(clientID, fields)
+	if err == nil {
+		// A client deleted in the meantime keeps its status
+		_, err = db.GetClientsCollection().UpdateOne(ctx,
+			bson.M{"_id": id, "status": statusProvisioning},
+			bson.M{"$set": bson.M{"status": statusReady, "provisioning.last_error": ""}},
+		)
+		if err != nil {
+			return fmt.Errorf("failed to mark client ready: %w", err)
+		}
+		return nil
+	}
+
+	if dropErr := db.DropClientDatabase(clientID); dropErr != nil {
+		log.Printf("Rolling back provisioning of client %s failed: %v", clientID, dropErr)
+	}
+	// The error is returned to admins as status_detail, so only its reason
+	// is stored; callers log the cause
+	nextAttempt := time.Now().UTC().Add(provisioningBackoff(attempts))
+	_, updateErr := db.GetClientsCollection().UpdateOne(ctx,
+		bson.M{"_id": id, "status": statusProvisioning},
+		bson.M{"$set": bson.M{
+			"status":                       statusFailed,
+			"provisioning.next_attempt_at": nextAttempt,
+			"provisioning.last_error":      toError(err).Reason,
+		}},
+	)
+	if updateErr != nil {
+		log.Printf("Failed to mark client %s as failed: %v", clientID, updateErr)
+	}
+	return fmt.Errorf("failed to provision client %s: %w", clientID, err)
+}
+
+// provisioningBackoff returns the delay before the next attempt, doubling
+// from a minute.
+func provisioningBackoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	return time.Minute << (attempts - 1)
+}
+
+// ReconcileClients repairs clients whose provisioning failed or was
+// abandoned by retrying it, and schedules clients that keep failing for
+// deletion. Clients are claimed one at a time, so several servers can
+// reconcile at once.
+func ReconcileClients(ctx context.Context) error {
+	for {
+		now := time.Now().UTC()
+		var c client
+		err := db.GetClientsCollection().FindOneAndUpdate(ctx,
+			bson.M{"$or": bson.A{
+				bson.M{"status": statusProvisioning, "provisioning.started_at": bson.M{"$lt": now.Add(-provisioningTimeout)}},
+				bson.M{
+					"status":                       statusFailed,
+					"provisioning.attempts":        bson.M{"$lt": maxProvisioningAttempts},
+					"provisioning.next_attempt_at": bson.M{"$lte": now},
+				},
+			}},
+			bson.M{
+				"$set": bson.M{"status": statusProvisioning, "provisioning.started_at": now},
+				"$inc": bson.M{"provisioning.attempts": 1},
+			},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&c)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to claim client for provisioning: %w", err)
+		}
+
+		if err := provisionClient(ctx, c.ID, c.Fields, c.Provisioning.Attempts); err != nil {
+			log.Printf("Provisioning attempt %d failed: %v", c.Provisioning.Attempts, err)
+			continue
+		}
+		log.Printf("Provisioned client %s on attempt %d", c.ID.Hex(), c.Provisioning.Attempts)
+	}
+
+	// Clients that cannot be provisioned are removed like deleted ones
+	result, err := db.GetClientsCollection().UpdateMany(ctx,
+		bson.M{"status": statusFailed, "provisioning.attempts": bson.M{"$gte": maxProvisioningAttempts}},
+		bson.M{"$set": bson.M{"status": statusPendingDeletion, "purge_after": time.Now().UTC()}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to schedule failed clients for deletion: %w", err)
+	}
+	if result.ModifiedCount > 0 {
+		log.Printf("Scheduled %d clients that could not be provisioned for deletion", result.ModifiedCount)
+	}
+	return nil
+}
+
+// UpgradeUserTables gives the users of clients whose tables predate user IDs
+// an ID, before requests read them. A client that cannot be upgraded is
+// logged and skipped; its logins fail until it is fixed.
+func UpgradeUserTables(ctx context.Context) error {
+	cursor, err := db.GetClientsCollection().Find(ctx,
+		bson.M{"status": bson.M{"$in": bson.A{statusReady, nil}}},
+		options.Find().SetProjection(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to find clients: %w", err)
+	}
+	var clients []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &clients); err != nil {
+		return fmt.Errorf("failed to find clients: %w", err)
+	}
+
+	for _, c := range clients {
+		if err := db.EnsureUserIDColumn(c.ID.Hex()); err != nil {
+			log.Printf("Failed to add user IDs to client %s: %v", c.ID.Hex(), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+// handlers/refresh.go
+package handlers
+
+import (
+	pb "auth-service/proto"
+	"auth-service/token"
+	"context"
+	"errors"
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+)
+
+// RefreshToken exchanges a refresh token for a new access and refresh token
+// pair. Every refresh token can be used once.
+func (s *AuthServiceServer) RefreshToken(ctx context.Context, req *pb.RefreshTokenRequest) (*pb.RefreshTokenResponse, error) {
+	// Unknown clients and clients not in use have no token tables
+	if _, err := loadClient(ctx, req.ClientId); err != nil {
+		return nil, err
+	}
+	subject, sessionID, refreshToken, err := token.RotateRefreshToken(ctx, req.ClientId, req.RefreshToken)
+	if errors.Is(err, token.ErrRefreshTokenReused) {
+		return nil, newError(codes.Unauthenticated, ReasonTokenReused, "refresh token reuse detected; the session has been revoked")
+	}
+	if errors.Is(err, token.ErrInvalidRefreshToken) {
+		return nil, errInvalidToken()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to refresh token: %w", err)
+	}
+
+	accessToken, expiresIn, err := token.IssueAccessToken(ctx, req.ClientId, subject, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue access token: %w", err)
+	}
+
+	return &pb.RefreshTokenResponse{
+		Message:      "Token refreshed successfully",
+		AccessToken:  accessToken,
+		TokenType:    token.Type,
+		ExpiresIn:    int64(expiresIn.Seconds()),
+		RefreshToken: refreshToken,
+	}, nil
+}
@@ -0,0 +1,109 @@
+// handlers/report.go
+package handlers
+
+import (
+	"auth-service/db"
+	"auth-service/password"
+	pb "auth-service/proto"
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GetLegacyCredentialReport counts, per client, how many users still have a
+// plaintext or outdated password stored.
+func (s *AdminServiceServer) GetLegacyCredentialReport(ctx context.Context, req *pb.LegacyCredentialReportRequest) (*pb.LegacyCredentialReportResponse, error) {
+	clientIDs := req.ClientIds
+	if len(clientIDs) == 0 {
+		ids, err := listClientIDs(ctx)
+		if err != nil {
+			return nil, err
+		}
+		clientIDs = ids
+	}
+
+	reports := make([]*pb.ClientCredentialReport, 0, len(clientIDs))
+	for _, clientID := range clientIDs {
+		report, err := credentialReport(ctx, clientID)
+		if err != nil {
+			// Only the reason is reported, the cause may contain SQL
+			e := toError(err)
+			if e.Err != nil {
+				log.Printf("Credential report of client %q failed: %v", clientID, e.Err)
+			}
+			report = &pb.ClientCredentialReport{ClientId: clientID, Error: e.Reason}
+		}
+		reports = append(reports, report)
+	}
+
+	return &pb.LegacyCredentialReportResponse{
+		Reports: reports,
+		Message: "Credential report generated successfully",
+	}, nil
+}
+
+// listClientIDs returns the IDs of all registered clients.
+func listClientIDs(ctx context.Context) ([]string, error) {
+	collection := db.GetClientsCollection()
+	cursor, err := collection.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list clients: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var ids []string
+	for cursor.Next(ctx) {
+		var client struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cursor.Decode(&client); err != nil {
+			return nil, fmt.Errorf("failed to decode client: %w", err)
+		}
+		ids = append(ids, client.ID.Hex())
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list clients: %w", err)
+	}
+	return ids, nil
+}
+
+// credentialReport classifies the stored password of every user of a client.
+func credentialReport(ctx context.Context, clientID string) (*pb.ClientCredentialReport, error) {
+	// The ID ends up in the query
+	if _, err := parseClientID(clientID); err != nil {
+		return nil, err
+	}
+	dbName := fmt.Sprintf("client_%s", clientID)
+	query := fmt.Sprintf("SELECT %s FROM %s.users", password.Field, dbName)
+	rows, err := db.MySQLClient.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read passwords: %w", err)
+	}
+	defer rows.Close()
+
+	report := &pb.ClientCredentialReport{ClientId: clientID}
+	for rows.Next() {
+		var stored sql.NullString
+		if err := rows.Scan(&stored); err != nil {
+			return nil, fmt.Errorf("failed to scan password: %w", err)
+		}
+		report.TotalUsers++
+		switch password.Classify(stored.String) {
+		case password.StatusLegacy:
+			report.LegacyPlaintext++
+		case password.StatusOutdated:
+			report.OutdatedHash++
+		default:
+			report.CurrentHash++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read passwords: %w", err)
+	}
+	return report, nil
+}
@@ -3,33 +3,98 @@ package handlers
 
 import (
 	"auth-service/db"
+	"auth-service/password"
 	pb "auth-service/proto"
+	"auth-service/schema"
 	"context"
 	"fmt"
+	"strings"
+
+	"google.golang.org/grpc/codes"
 )
 
 func (s *AuthServiceServer) Signup(ctx context.Context, req *pb.SignupRequest) (*pb.SignupResponse, error) {
-	dbName := fmt.Sprintf("client_%s", req.ClientId)
-	tableName := "users"
+	c, err := loadClient(ctx, req.ClientId)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.primaryKeyField(req.PrimaryKeyField); err != nil {
+		return nil, err
+	}
+	if c.field(password.Field) == nil {
+		return nil, newError(codes.FailedPrecondition, ReasonClientMisconfigured, "client schema has no password field")
+	}
 
-	fields := ""
-	placeholders := ""
-	values := []interface{}{}
-	for field, value := range req.UserData {
-		fields += fmt.Sprintf("%s, ", field)
-		placeholders += "?, "
-		values = append(values, value)
+	// Only columns of the stored schema are ever written. The primary key is
+	// always required, and so is a password.
+	violations := schema.ValidateRecord(c.Fields, req.UserData)
+	if req.UserData[password.Field] == "" && !c.field(password.Field).Required {
+		violations = append(violations, schema.Violation{Field: password.Field, Description: "field is required"})
+	}
+	if len(violations) > 0 {
+		return nil, userDataError(violations)
 	}
-	fields = fields[:len(fields)-2]
-	placeholders = placeholders[:len(placeholders)-2]
 
-	query := fmt.Sprintf("INSERT INTO %s.%s (%s) VALUES (%s)", dbName, tableName, fields, placeholders)
-	_, err := db.MySQLClient.Exec(query, values...)
+	// Tables of clients registered before schemas were validated may have
+	// no unique index on the primary key, so look for the user first
+	if pk := c.field(c.PrimaryKeyField); !pk.Unique {
+		var exists bool
+		query := fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM client_%s.users WHERE `%s` = ?)", req.ClientId, pk.Name)
+		if err := db.MySQLClient.QueryRowContext(ctx, query, req.UserData[pk.Name]).Scan(&exists); err != nil {
+			return nil, fmt.Errorf("failed to look up user: %w", err)
+		}
+		if exists {
+			e := newError(codes.AlreadyExists, ReasonUserExists, "a user with this %s already exists", pk.Name)
+			e.Metadata = map[string]string{"field": pk.Name}
+			return nil, e
+		}
+	}
+
+	hash, err := password.Hash(req.UserData[password.Field])
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	userID, err := db.NewUserID()
+	if err != nil {
+		return nil, err
+	}
+
+	columns := []string{fmt.Sprintf("`%s`", schema.UserIDColumn)}
+	placeholders := []string{"?"}
+	values := []interface{}{userID}
+	for _, f := range c.Fields {
+		value, ok := req.UserData[f.Name]
+		if !ok || value == "" {
+			continue
+		}
+		columns = append(columns, fmt.Sprintf("`%s`", f.Name))
+		placeholders = append(placeholders, "?")
+		if f.Name == password.Field {
+			values = append(values, hash)
+		} else {
+			values = append(values, f.SQLValue(value))
+		}
+	}
+
+	dbName := fmt.Sprintf("client_%s", req.ClientId)
+	tableName := "users"
+	query := fmt.Sprintf("INSERT INTO %s.%s (%s) VALUES (%s)", dbName, tableName, strings.Join(columns, ", "), strings.Join(placeholders, ", "))
+	_, err = db.MySQLClient.Exec(query, values...)
+	if key, ok := db.DuplicateKey(err); ok {
+		e := newError(codes.AlreadyExists, ReasonUserExists, "user already exists")
+		if field := strings.TrimPrefix(key, "uq_"); c.field(field) != nil {
+			e.Message = fmt.Sprintf("a user with this %s already exists", field)
+			e.Metadata = map[string]string{"field": field}
+		}
+		return nil, e
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to insert user: %w", err)
 	}
 
 	return &pb.SignupResponse{
 		Message: "Signup successful",
+		UserId:  userID,
 	}, nil
 }
@@ -3,10 +3,16 @@ package main
 import (
 	"auth-service/db"
 	"auth-service/handlers"
+	"auth-service/password"
 	pb "auth-service/proto"
+	"auth-service/token"
+	"context"
+	"encoding/base64"
+	"errors"
 	"log"
 	"net"
 	"os"
+	"time"
 
 	"github.com/joho/godotenv"
 	"google.golang.org/grpc"
@@ -23,20 +29,149 @@ func main() {
 		log.Fatalf("MongoDB connection failed: %v", err)
 	}
 
+	// Control plane indexes and owners of clients registered before them
+	if err := handlers.NormalizeClientEmails(context.Background()); err != nil {
+		log.Fatalf("Failed to normalize client emails: %v", err)
+	}
+	if err := db.EnsureIndexes(context.Background()); errors.Is(err, db.ErrDuplicateClientEmails) {
+		log.Printf("Client emails are not unique; registration checks them, but resolve the duplicates so the index can be built")
+	} else if err != nil {
+		log.Fatalf("Failed to create MongoDB indexes: %v", err)
+	}
+	if err := handlers.AssignOwners(context.Background()); err != nil {
+		log.Fatalf("Failed to assign owners to clients: %v", err)
+	}
+
 	// MySQL Connection
 	if err := db.ConnectMySQL(os.Getenv("MYSQL_TEST_DSN")); err != nil {
 		log.Fatalf("MySQL connection failed: %v", err)
 	}
+	if err := handlers.UpgradeUserTables(context.Background()); err != nil {
+		log.Fatalf("Failed to upgrade user tables: %v", err)
+	}
+
+	// Password hashing
+	if alg := os.Getenv("PASSWORD_HASH_ALGORITHM"); alg != "" {
+		if err := password.SetAlgorithm(alg); err != nil {
+			log.Fatalf("Invalid password hashing configuration: %v", err)
+		}
+	}
 
-	// gRPC Server
-	lis, err := net.Listen("tcp", ":50051")
+	// Token signing
+	accessTTL := 15 * time.Minute
+	if ttl := os.Getenv("ACCESS_TOKEN_TTL"); ttl != "" {
+		if accessTTL, err = time.ParseDuration(ttl); err != nil {
+			log.Fatalf("Invalid ACCESS_TOKEN_TTL: %v", err)
+		}
+	}
+	refreshTTL := 30 * 24 * time.Hour
+	if ttl := os.Getenv("REFRESH_TOKEN_TTL"); ttl != "" {
+		if refreshTTL, err = time.ParseDuration(ttl); err != nil {
+			log.Fatalf("Invalid REFRESH_TOKEN_TTL: %v", err)
+		}
+	}
+	var keyEncryptionKey []byte
+	if kek := os.Getenv("KEY_ENCRYPTION_KEY"); kek != "" {
+		if keyEncryptionKey, err = base64.StdEncoding.DecodeString(kek); err != nil {
+			log.Fatalf("Invalid KEY_ENCRYPTION_KEY: %v", err)
+		}
+	}
+	err = token.Init(token.Config{
+		KeystoreDir: getenv("JWT_KEYSTORE_DIR", "keys"),
+		ActiveKeyID: os.Getenv("JWT_ACTIVE_KID"),
+		Algorithm:   getenv("JWT_SIGNING_ALGORITHM", token.ES256),
+		Issuer:      getenv("JWT_ISSUER", "auth-service"),
+		AccessTTL:   accessTTL,
+		RefreshTTL:  refreshTTL,
+
+		KeyEncryptionKey: keyEncryptionKey,
+	})
+	if err != nil {
+		log.Fatalf("Failed to load signing keys: %v", err)
+	}
+
+	// Signing key rotation
+	var rotationInterval time.Duration
+	if interval := os.Getenv("JWT_ROTATION_INTERVAL"); interval != "" {
+		if rotationInterval, err = time.ParseDuration(interval); err != nil {
+			log.Fatalf("Invalid JWT_ROTATION_INTERVAL: %v", err)
+		}
+	}
+	rotationGrace, err := time.ParseDuration(getenv("JWT_ROTATION_GRACE", "1h"))
 	if err != nil {
-		log.Fatalf("Failed to listen on port 50051: %v", err)
+		log.Fatalf("Invalid JWT_ROTATION_GRACE: %v", err)
 	}
-	s := grpc.NewServer()
+	go func() {
+		for range time.Tick(time.Minute) {
+			if err := token.MaintainKeys(rotationInterval, rotationGrace); err != nil {
+				log.Printf("Signing key maintenance failed: %v", err)
+			}
+		}
+	}()
+
+	// Admin credentials
+	handlers.SetBootstrapAdminKey(os.Getenv("ADMIN_BOOTSTRAP_KEY"))
+	if os.Getenv("ADMIN_BOOTSTRAP_KEY") == "" {
+		log.Printf("ADMIN_BOOTSTRAP_KEY is not set; only stored admin keys can manage clients")
+	}
+
+	// Client provisioning and deletion
+	if grace := os.Getenv("CLIENT_DELETION_GRACE"); grace != "" {
+		deletionGrace, err := time.ParseDuration(grace)
+		if err != nil {
+			log.Fatalf("Invalid CLIENT_DELETION_GRACE: %v", err)
+		}
+		handlers.SetClientDeletionGrace(deletionGrace)
+	}
+	go func() {
+		for range time.Tick(time.Minute) {
+			if err := handlers.ReconcileClients(context.Background()); err != nil {
+				log.Printf("Reconciling clients failed: %v", err)
+			}
+			if err := handlers.PurgeDeletedClients(context.Background()); err != nil {
+				log.Printf("Purging deleted clients failed: %v", err)
+			}
+		}
+	}()
+
+	// gRPC Servers. The AdminService shares the public listener unless it
+	// is given one of its own, which can be kept off the public network.
+	addr := getenv("GRPC_ADDR", ":50051")
+	adminAddr := os.Getenv("ADMIN_GRPC_ADDR")
+	s := newServer()
 	pb.RegisterAuthServiceServer(s, &handlers.AuthServiceServer{})
-	log.Printf("Server is listening on port 50051")
+	if adminAddr == "" || adminAddr == addr {
+		pb.RegisterAdminServiceServer(s, &handlers.AdminServiceServer{})
+		serve(s, addr, "AuthService and AdminService")
+		return
+	}
+	admin := newServer()
+	pb.RegisterAdminServiceServer(admin, &handlers.AdminServiceServer{})
+	go serve(admin, adminAddr, "AdminService")
+	serve(s, addr, "AuthService")
+}
+
+// newServer returns a gRPC server with the service's interceptors.
+func newServer() *grpc.Server {
+	return grpc.NewServer(grpc.ChainUnaryInterceptor(handlers.ErrorInterceptor, handlers.AuthInterceptor))
+}
+
+// serve serves s on addr until it fails, which ends the process.
+func serve(s *grpc.Server, addr, services string) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", addr, err)
+	}
+	log.Printf("%s listening on %s", services, addr)
 	if err := s.Serve(lis); err != nil {
-		log.Fatalf("Failed to serve: %v", err)
+		log.Fatalf("Failed to serve %s: %v", services, err)
+	}
+}
+
+// getenv returns the environment variable or fallback when it is unset.
+func getenv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
 	}
+	return fallback
 }
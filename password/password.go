@@ -0,0 +1,338 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Field is the user column that holds the password hash.
+const Field = "password"
+
+// ColumnType is the MySQL type used for the password column so that every
+// supported encoding fits.
+const ColumnType = "VARCHAR(255)"
+
+type Algorithm string
+
+const (
+	Argon2id Algorithm = "argon2id"
+	Bcrypt   Algorithm = "bcrypt"
+	Scrypt   Algorithm = "scrypt"
+)
+
+// Policy holds the parameters used when hashing new passwords. The
+// parameters are encoded into every hash, so changing them later does not
+// affect passwords that were hashed before.
+type Policy struct {
+	Algorithm Algorithm
+
+	// argon2id
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+
+	// bcrypt
+	Cost int
+
+	// scrypt (N = 2^LogN)
+	LogN uint8
+	R    int
+	P    int
+
+	SaltLength uint32
+	KeyLength  uint32
+}
+
+var DefaultPolicy = Policy{
+	Algorithm:   Argon2id,
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	Cost:        12,
+	LogN:        15,
+	R:           8,
+	P:           1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// Current is the policy applied by Hash.
+var Current = DefaultPolicy
+
+var ErrUnknownFormat = errors.New("unrecognized password hash format")
+
+// Status describes a stored password value relative to the current policy.
+type Status int
+
+const (
+	// StatusLegacy is a value that is not a recognized hash, i.e. a
+	// plaintext password written before hashing was introduced.
+	StatusLegacy Status = iota
+	// StatusOutdated is a hash made with another algorithm or with
+	// parameters below the current policy.
+	StatusOutdated
+	// StatusCurrent is a hash that satisfies the current policy.
+	StatusCurrent
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusLegacy:
+		return "legacy"
+	case StatusOutdated:
+		return "outdated"
+	default:
+		return "current"
+	}
+}
+
+// SetAlgorithm switches the algorithm used for new hashes, keeping the
+// default parameters for it.
+func SetAlgorithm(name string) error {
+	switch alg := Algorithm(strings.ToLower(name)); alg {
+	case Argon2id, Bcrypt, Scrypt:
+		Current.Algorithm = alg
+		return nil
+	default:
+		return fmt.Errorf("unsupported password hash algorithm %q", name)
+	}
+}
+
+// Hash hashes the password with the current policy and returns the encoded
+// hash including its algorithm and parameters.
+func Hash(password string) (string, error) {
+	return Current.Hash(password)
+}
+
+// Hash hashes the password with the policy p.
+func (p Policy) Hash(password string) (string, error) {
+	switch p.Algorithm {
+	case Argon2id:
+		salt, err := newSalt(p.SaltLength)
+		if err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+			argon2.Version, p.Memory, p.Iterations, p.Parallelism, encode(salt), encode(key)), nil
+	case Bcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), p.Cost)
+		if err != nil {
+			return "", fmt.Errorf("failed to hash password: %w", err)
+		}
+		return string(hash), nil
+	case Scrypt:
+		salt, err := newSalt(p.SaltLength)
+		if err != nil {
+			return "", err
+		}
+		key, err := scrypt.Key([]byte(password), salt, 1<<p.LogN, p.R, p.P, int(p.KeyLength))
+		if err != nil {
+			return "", fmt.Errorf("failed to hash password: %w", err)
+		}
+		return fmt.Sprintf("$scrypt$ln=%d,r=%d,p=%d$%s$%s", p.LogN, p.R, p.P, encode(salt), encode(key)), nil
+	default:
+		return "", fmt.Errorf("unsupported password hash algorithm %q", p.Algorithm)
+	}
+}
+
+// Verify reports whether password matches the encoded hash. The comparison
+// runs in constant time.
+func Verify(password, encoded string) (bool, error) {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		var version int
+		var p Policy
+		salt, key, err := decodeParts(encoded, 6, func(parts []string) error {
+			if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+				return err
+			}
+			if version != argon2.Version {
+				return fmt.Errorf("unsupported argon2 version %d", version)
+			}
+			_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism)
+			return err
+		})
+		if err != nil {
+			return false, err
+		}
+		other := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, uint32(len(key)))
+		return subtle.ConstantTimeCompare(key, other) == 1, nil
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+	case strings.HasPrefix(encoded, "$scrypt$"):
+		var p Policy
+		salt, key, err := decodeParts(encoded, 5, func(parts []string) error {
+			_, err := fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &p.LogN, &p.R, &p.P)
+			return err
+		})
+		if err != nil {
+			return false, err
+		}
+		other, err := scrypt.Key([]byte(password), salt, 1<<p.LogN, p.R, p.P, len(key))
+		if err != nil {
+			return false, fmt.Errorf("failed to hash password: %w", err)
+		}
+		return subtle.ConstantTimeCompare(key, other) == 1, nil
+	default:
+		return false, ErrUnknownFormat
+	}
+}
+
+// Check verifies password against a stored value that is either an encoded
+// hash or a legacy plaintext password, and reports whether the stored value
+// should be replaced with a fresh hash.
+func Check(password, stored string) (ok, rehash bool, err error) {
+	status := Classify(stored)
+	if status == StatusLegacy {
+		if stored == "" {
+			return false, false, nil
+		}
+		// Compare digests so the comparison does not depend on the lengths.
+		a, b := sha256.Sum256([]byte(password)), sha256.Sum256([]byte(stored))
+		ok = subtle.ConstantTimeCompare(a[:], b[:]) == 1
+		return ok, ok, nil
+	}
+	ok, err = Verify(password, stored)
+	return ok, ok && status == StatusOutdated, err
+}
+
+// dummy is a hash of a random password under the policy it was made with.
+var dummy struct {
+	sync.Mutex
+	policy Policy
+	hash   string
+}
+
+// VerifyDummy verifies password against a hash no password matches, taking
+// as long as checking a user's hash under the current policy, and reports
+// false. Callers rejecting an unknown user call it so that the response time
+// does not tell unknown users from wrong passwords.
+func VerifyDummy(password string) bool {
+	hash, err := DummyHash()
+	if err != nil {
+		return false
+	}
+	ok, _ := Verify(password, hash)
+	return ok
+}
+
+// DummyHash returns the hash VerifyDummy checks against. It is made once
+// per policy, under the current policy.
+func DummyHash() (string, error) {
+	dummy.Lock()
+	defer dummy.Unlock()
+	if dummy.hash != "" && dummy.policy == Current {
+		return dummy.hash, nil
+	}
+	secret, err := newSalt(32)
+	if err != nil {
+		return "", err
+	}
+	hash, err := Current.Hash(encode(secret))
+	if err != nil {
+		return "", err
+	}
+	dummy.policy, dummy.hash = Current, hash
+	return hash, nil
+}
+
+// Classify reports whether the stored value is a legacy plaintext password,
+// an outdated hash or a hash that satisfies the current policy.
+func Classify(stored string) Status {
+	return Current.Classify(stored)
+}
+
+// Classify compares the stored value against the policy p.
+func (p Policy) Classify(stored string) Status {
+	parts := strings.Split(stored, "$")
+	switch {
+	case strings.HasPrefix(stored, "$argon2id$"):
+		var m, t uint32
+		var par uint8
+		if len(parts) != 6 {
+			return StatusOutdated
+		}
+		if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &m, &t, &par); err != nil {
+			return StatusOutdated
+		}
+		key, err := decode(parts[5])
+		if err != nil || p.Algorithm != Argon2id ||
+			m < p.Memory || t < p.Iterations || par < p.Parallelism || uint32(len(key)) < p.KeyLength {
+			return StatusOutdated
+		}
+		return StatusCurrent
+	case strings.HasPrefix(stored, "$2a$"), strings.HasPrefix(stored, "$2b$"), strings.HasPrefix(stored, "$2y$"):
+		cost, err := bcrypt.Cost([]byte(stored))
+		if err != nil || p.Algorithm != Bcrypt || cost < p.Cost {
+			return StatusOutdated
+		}
+		return StatusCurrent
+	case strings.HasPrefix(stored, "$scrypt$"):
+		var ln uint8
+		var r, par int
+		if len(parts) != 5 {
+			return StatusOutdated
+		}
+		if _, err := fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &ln, &r, &par); err != nil {
+			return StatusOutdated
+		}
+		key, err := decode(parts[4])
+		if err != nil || p.Algorithm != Scrypt ||
+			ln < p.LogN || r < p.R || par < p.P || uint32(len(key)) < p.KeyLength {
+			return StatusOutdated
+		}
+		return StatusCurrent
+	default:
+		return StatusLegacy
+	}
+}
+
+// decodeParts splits an encoded hash of the form $alg$params...$salt$key,
+// lets parseParams read the parameter segments and decodes salt and key.
+func decodeParts(encoded string, n int, parseParams func(parts []string) error) (salt, key []byte, err error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != n {
+		return nil, nil, ErrUnknownFormat
+	}
+	if err := parseParams(parts); err != nil {
+		return nil, nil, fmt.Errorf("invalid password hash parameters: %w", err)
+	}
+	if salt, err = decode(parts[n-2]); err != nil {
+		return nil, nil, fmt.Errorf("invalid password hash salt: %w", err)
+	}
+	if key, err = decode(parts[n-1]); err != nil {
+		return nil, nil, fmt.Errorf("invalid password hash key: %w", err)
+	}
+	return salt, key, nil
+}
+
+func newSalt(n uint32) ([]byte, error) {
+	salt := make([]byte, n)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	return salt, nil
+}
+
+func encode(b []byte) string {
+	return base64.RawStdEncoding.EncodeToString(b)
+}
+
+func decode(s string) ([]byte, error) {
+	return base64.RawStdEncoding.DecodeString(s)
+}
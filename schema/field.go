@@ -0,0 +1,582 @@
+package schema
+
+import (
+	"auth-service/password"
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Type is the logical type of a field, independent of the storage engine.
+type Type string
+
+const (
+	String    Type = "STRING"
+	Text      Type = "TEXT"
+	Email     Type = "EMAIL"
+	Phone     Type = "PHONE"
+	Int       Type = "INT"
+	Float     Type = "FLOAT"
+	Bool      Type = "BOOL"
+	Date      Type = "DATE"
+	Timestamp Type = "TIMESTAMP"
+	JSON      Type = "JSON"
+)
+
+// typeInfo describes how a logical type is stored and bounded.
+type typeInfo struct {
+	sqlType       string // %d is replaced by the maximum length
+	defaultLength int    // zero for types without a length
+	maxLength     int
+	indexable     bool
+}
+
+var types = map[Type]typeInfo{
+	String:    {sqlType: "VARCHAR(%d)", defaultLength: 255, maxLength: 16383, indexable: true},
+	Text:      {sqlType: "TEXT", maxLength: 65535},
+	Email:     {sqlType: "VARCHAR(%d)", defaultLength: 254, maxLength: 320, indexable: true},
+	Phone:     {sqlType: "VARCHAR(%d)", defaultLength: 32, maxLength: 64, indexable: true},
+	Int:       {sqlType: "BIGINT", indexable: true},
+	Float:     {sqlType: "DOUBLE", indexable: true},
+	Bool:      {sqlType: "BOOLEAN", indexable: true},
+	Date:      {sqlType: "DATE", indexable: true},
+	Timestamp: {sqlType: "DATETIME", indexable: true},
+	JSON:      {sqlType: "JSON"},
+}
+
+// maxIndexedLength is the longest VARCHAR that fits in an InnoDB index with
+// utf8mb4 (3072 bytes).
+const maxIndexedLength = 768
+
+// Field is one field of a client schema as stored on the client document.
+type Field struct {
+	Name      string  `bson:"name"`
+	Type      Type    `bson:"type"`
+	MaxLength int     `bson:"max_length,omitempty"`
+	Required  bool    `bson:"required,omitempty"`
+	Unique    bool    `bson:"unique,omitempty"`
+	Indexed   bool    `bson:"indexed,omitempty"`
+	Default   *string `bson:"default,omitempty"`
+	Sensitive bool    `bson:"sensitive,omitempty"`
+	// Deprecated fields keep their column and data but are no longer
+	// written or returned.
+	Deprecated bool `bson:"deprecated,omitempty"`
+
+	// SQLType overrides the column type derived from Type. It is set for
+	// schemas given as raw MySQL types and for the password column.
+	SQLType string `bson:"sql_type,omitempty"`
+}
+
+// ColumnType returns the MySQL type of the field's column.
+func (f Field) ColumnType() string {
+	if f.SQLType != "" {
+		return f.SQLType
+	}
+	info := types[f.Type]
+	if info.defaultLength > 0 {
+		return fmt.Sprintf(info.sqlType, f.length())
+	}
+	return info.sqlType
+}
+
+// length returns the maximum length of the field, applying the default of
+// its type.
+func (f Field) length() int {
+	if f.MaxLength > 0 {
+		return f.MaxLength
+	}
+	return types[f.Type].defaultLength
+}
+
+// ValidateFields checks a typed client schema and returns every violation.
+// The password field, if any, is stored as a hash and therefore always
+// sensitive with a column wide enough for encoded hashes.
+func ValidateFields(fields []Field, primaryKeyField string) ([]Field, []Violation) {
+	var violations []Violation
+	if len(fields) == 0 {
+		violations = append(violations, Violation{Field: "fields", Description: "schema must define at least one field"})
+	}
+	if len(fields) > MaxFields {
+		violations = append(violations, Violation{Field: "fields", Description: fmt.Sprintf("schema may define at most %d fields", MaxFields)})
+	}
+
+	validated := make([]Field, 0, len(fields))
+	seen := map[string]string{}
+	var primaryKey *Field
+	for _, f := range fields {
+		violation := func(format string, args ...interface{}) {
+			violations = append(violations, Violation{Field: f.Name, Description: fmt.Sprintf(format, args...)})
+		}
+
+		if description := checkIdentifier(f.Name); description != "" {
+			violation("%s", description)
+		} else if other, ok := seen[strings.ToLower(f.Name)]; ok {
+			violation("field name conflicts with %q; column names are case-insensitive", other)
+		}
+		seen[strings.ToLower(f.Name)] = f.Name
+
+		info, ok := types[f.Type]
+		switch {
+		case f.Type == "":
+			violation("field type is required")
+		case !ok:
+			violation("unsupported field type %q", f.Type)
+		case f.MaxLength < 0:
+			violation("max_length must not be negative")
+		case f.MaxLength > 0 && info.maxLength == 0:
+			violation("%s fields do not take a max_length", f.Type)
+		case f.MaxLength > info.maxLength:
+			violation("max_length of %s fields must be at most %d", f.Type, info.maxLength)
+		}
+		if ok && (f.Unique || f.Indexed) {
+			if !info.indexable {
+				violation("%s fields cannot be unique or indexed", f.Type)
+			} else if info.defaultLength > 0 && f.length() > maxIndexedLength {
+				violation("unique or indexed fields must have a max_length of at most %d", maxIndexedLength)
+			}
+		}
+
+		if f.Deprecated && (f.Required || f.Name == password.Field || f.Name == primaryKeyField) {
+			violation("the password field, the primary key and required fields cannot be deprecated")
+		}
+
+		if f.Name == password.Field {
+			if f.Type != String {
+				violation("the password field must be of type STRING")
+			}
+			if f.Unique || f.Indexed || f.Default != nil {
+				violation("the password field cannot be unique, indexed or have a default")
+			}
+			f.Sensitive, f.MaxLength, f.SQLType = true, 0, password.ColumnType
+		}
+
+		if f.Default != nil {
+			switch {
+			case !ok:
+			case f.Type == Text || f.Type == JSON:
+				violation("%s fields cannot have a default", f.Type)
+			case !(f.Type == Timestamp && *f.Default == "CURRENT_TIMESTAMP"):
+				if description := f.CheckValue(*f.Default); description != "" {
+					violation("invalid default: %s", description)
+				}
+			}
+		}
+
+		validated = append(validated, f)
+		if f.Name == primaryKeyField {
+			primaryKey = &validated[len(validated)-1]
+		}
+	}
+
+	switch {
+	case primaryKeyField == "":
+		violations = append(violations, Violation{Field: "primary_key_field", Description: "primary key field is required"})
+	case primaryKey == nil:
+		violations = append(violations, Violation{Field: "primary_key_field", Description: fmt.Sprintf("primary key field %q is not defined in the schema", primaryKeyField)})
+	case primaryKey.Sensitive:
+		violations = append(violations, Violation{Field: "primary_key_field", Description: "primary key field cannot be sensitive"})
+	case !types[primaryKey.Type].indexable || (types[primaryKey.Type].defaultLength > 0 && primaryKey.length() > maxIndexedLength):
+		violations = append(violations, Violation{Field: "primary_key_field", Description: fmt.Sprintf("primary key field must be indexable, with a max_length of at most %d", maxIndexedLength)})
+	default:
+		// Users are looked up by their primary key, so it is always required
+		// and unique
+		primaryKey.Required, primaryKey.Unique = true, true
+	}
+	if size := rowBytes(validated); size > maxRowBytes {
+		violations = append(violations, Violation{Field: "fields", Description: fmt.Sprintf("rows take up to %d bytes, more than the %d MySQL allows", size, maxRowBytes)})
+	}
+	return validated, violations
+}
+
+// maxRowBytes is the limit of MySQL on the size of a row, not counting TEXT
+// and JSON values, which are stored apart from it.
+const maxRowBytes = 65535
+
+// rowBytes returns the most bytes a row of the user table takes.
+func rowBytes(fields []Field) int {
+	// The surrogate ID and the user ID
+	size := 8 + 4*36
+	for _, f := range fields {
+		size += columnBytes(splitType(f.ColumnType()))
+	}
+	return size
+}
+
+// columnBytes returns the most bytes a column of the MySQL type takes in a
+// row, assuming utf8mb4.
+func columnBytes(name string, length int) int {
+	switch name {
+	case "VARCHAR":
+		if 4*length > 255 {
+			return 4*length + 2
+		}
+		return 4*length + 1
+	case "CHAR":
+		return 4 * length
+	case "TEXT", "JSON":
+		// The pointer to the value
+		return 12
+	case "BOOLEAN", "TINYINT":
+		return 1
+	case "SMALLINT":
+		return 2
+	case "DATE":
+		return 3
+	case "INT", "FLOAT":
+		return 4
+	case "DATETIME", "TIMESTAMP":
+		return 5
+	case "DECIMAL":
+		// 4 bytes per 9 digits, with the integer and fractional parts
+		// rounded up separately
+		return (length+8)/9*4 + 4
+	}
+	// BIGINT and DOUBLE
+	return 8
+}
+
+// ValidateProfileFields checks the fields a client returns to logged in
+// users. They have to be defined in the schema and must not be sensitive or
+// deprecated.
+func ValidateProfileFields(fields []Field, names []string) []Violation {
+	var violations []Violation
+	seen := map[string]bool{}
+	for _, name := range names {
+		var field *Field
+		for i := range fields {
+			if fields[i].Name == name {
+				field = &fields[i]
+			}
+		}
+		switch {
+		case field == nil:
+			violations = append(violations, Violation{Field: "profile_fields", Description: fmt.Sprintf("profile field %q is not defined in the schema", name)})
+		case field.Sensitive:
+			violations = append(violations, Violation{Field: "profile_fields", Description: fmt.Sprintf("profile field %q is sensitive", name)})
+		case field.Deprecated:
+			violations = append(violations, Violation{Field: "profile_fields", Description: fmt.Sprintf("profile field %q is deprecated", name)})
+		case seen[name]:
+			violations = append(violations, Violation{Field: "profile_fields", Description: fmt.Sprintf("profile field %q is listed twice", name)})
+		}
+		seen[name] = true
+	}
+	return violations
+}
+
+// FromMap converts a schema of field names to raw MySQL types into fields,
+// validating it like Validate. The fields keep the MySQL types; their
+// logical types are inferred. The primary key comes first, the other fields
+// follow in name order.
+func FromMap(schema map[string]string, primaryKeyField string) ([]Field, []Violation) {
+	normalized, violations := Validate(schema, primaryKeyField)
+
+	fields := make([]Field, 0, len(normalized))
+	for _, name := range fieldOrder(normalized, primaryKeyField) {
+		f := Field{Name: name, SQLType: normalized[name]}
+		f.Type, f.MaxLength = inferType(normalized[name])
+		if name == password.Field {
+			f.Type, f.MaxLength, f.SQLType, f.Sensitive = String, 0, password.ColumnType, true
+		}
+		if name == primaryKeyField {
+			if !types[f.Type].indexable || (types[f.Type].defaultLength > 0 && f.length() > maxIndexedLength) {
+				violations = append(violations, Violation{Field: "primary_key_field", Description: fmt.Sprintf("primary key field must be indexable, with a length of at most %d", maxIndexedLength)})
+			}
+			f.Required, f.Unique = true, true
+		}
+		fields = append(fields, f)
+	}
+	if size := rowBytes(fields); size > maxRowBytes {
+		violations = append(violations, Violation{Field: "schema", Description: fmt.Sprintf("rows take up to %d bytes, more than the %d MySQL allows", size, maxRowBytes)})
+	}
+	return fields, violations
+}
+
+// fieldOrder returns the names of a schema map with the primary key first
+// and the other fields in name order.
+func fieldOrder(schema map[string]string, primaryKeyField string) []string {
+	names := make([]string, 0, len(schema))
+	for name := range schema {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if (names[i] == primaryKeyField) != (names[j] == primaryKeyField) {
+			return names[i] == primaryKeyField
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// storedColumnPattern splits a column definition stored by clients
+// registered before schemas were validated into its type and modifiers,
+// e.g. "VARCHAR(50)" and " NOT NULL UNIQUE".
+var storedColumnPattern = regexp.MustCompile(`^\s*([A-Za-z]+\s*(?:\([^)]*\))?)(.*)$`)
+
+// defaultPattern matches the DEFAULT modifier of a stored column definition.
+var defaultPattern = regexp.MustCompile(`(?i)\bDEFAULT\s+('(?:[^']|'')*'|[^\s,]+)`)
+
+// FromStored derives the fields of a client registered before schemas were
+// validated from its stored map of field names to column definitions. The
+// columns exist, so types the current rules reject are kept as they are;
+// only names that cannot be columns or are reserved for the service's own
+// columns are left out. NOT NULL, UNIQUE, PRIMARY
+// KEY and DEFAULT modifiers carry over. The primary key is required, but
+// only unique if its column has a unique index.
+func FromStored(schema map[string]string, primaryKeyField string) []Field {
+	fields := make([]Field, 0, len(schema))
+	for _, name := range fieldOrder(schema, primaryKeyField) {
+		if !identifierPattern.MatchString(name) || strings.EqualFold(name, IDColumn) || strings.EqualFold(name, UserIDColumn) {
+			continue
+		}
+		f := Field{Name: name, Type: Text}
+		if match := storedColumnPattern.FindStringSubmatch(schema[name]); match != nil {
+			sqlType, description := NormalizeType(match[1])
+			if description != "" {
+				sqlType = strings.ToUpper(strings.Join(strings.Fields(match[1]), ""))
+			}
+			f.SQLType = sqlType
+			f.Type, f.MaxLength = inferType(sqlType)
+
+			modifiers := " " + strings.Join(strings.Fields(strings.ToUpper(match[2])), " ") + " "
+			primaryKey := strings.Contains(modifiers, " PRIMARY KEY ")
+			f.Required = primaryKey || strings.Contains(modifiers, " NOT NULL ")
+			f.Unique = primaryKey || strings.Contains(modifiers, " UNIQUE ")
+			if value := defaultPattern.FindStringSubmatch(match[2]); value != nil && !strings.EqualFold(value[1], "NULL") {
+				literal := value[1]
+				if strings.HasPrefix(literal, "'") {
+					literal = strings.ReplaceAll(literal[1:len(literal)-1], "''", "'")
+				}
+				f.Default = &literal
+			}
+		}
+		if name == password.Field {
+			f.Type, f.MaxLength, f.SQLType, f.Sensitive, f.Unique, f.Default = String, 0, password.ColumnType, true, false, nil
+		}
+		if name == primaryKeyField {
+			f.Required = true
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+var lengthPattern = regexp.MustCompile(`^(?:VAR)?CHAR\((\d+)\)$`)
+
+// inferType maps a canonical MySQL type to the logical type it stores.
+func inferType(sqlType string) (Type, int) {
+	if match := lengthPattern.FindStringSubmatch(sqlType); match != nil {
+		length, _ := strconv.Atoi(match[1])
+		return String, length
+	}
+	name := sqlType
+	if i := strings.IndexByte(name, '('); i >= 0 {
+		name = name[:i]
+	}
+	switch name {
+	case "TINYINT", "SMALLINT", "INT", "BIGINT":
+		return Int, 0
+	case "DECIMAL", "FLOAT", "DOUBLE":
+		return Float, 0
+	case "BOOLEAN":
+		return Bool, 0
+	case "DATE":
+		return Date, 0
+	case "DATETIME", "TIMESTAMP":
+		return Timestamp, 0
+	case "JSON":
+		return JSON, 0
+	default:
+		return Text, 0
+	}
+}
+
+var phonePattern = regexp.MustCompile(`^\+?[0-9][0-9 ().-]*$`)
+
+// CheckValue returns why value is not valid for the field, or an empty
+// string if it is.
+func (f Field) CheckValue(value string) string {
+	if max := f.length(); max > 0 && f.SQLType != password.ColumnType && utf8.RuneCountInString(value) > max {
+		return fmt.Sprintf("must be at most %d characters long", max)
+	}
+	switch f.Type {
+	case Email:
+		if address, err := mail.ParseAddress(value); err != nil || address.Address != value {
+			return "must be a valid email address"
+		}
+	case Phone:
+		digits := 0
+		for _, r := range value {
+			if r >= '0' && r <= '9' {
+				digits++
+			}
+		}
+		if !phonePattern.MatchString(value) || digits < 4 || digits > 15 {
+			return "must be a valid phone number"
+		}
+	case Int:
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return "must be an integer"
+		}
+	case Float:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return "must be a number"
+		}
+	case Bool:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return "must be true or false"
+		}
+	case Date:
+		if _, err := time.Parse(time.DateOnly, value); err != nil {
+			return "must be a date formatted as YYYY-MM-DD"
+		}
+	case Timestamp:
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			return "must be an RFC 3339 timestamp"
+		}
+	case JSON:
+		if !json.Valid([]byte(value)) {
+			return "must be valid JSON"
+		}
+	}
+	return ""
+}
+
+// TableDefinitions returns the column and index definitions of a user table
+// for the fields, in field order, after the surrogate ID and user ID columns.
+func TableDefinitions(fields []Field) []string {
+	columns := []string{
+		fmt.Sprintf("`%s` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT", IDColumn),
+		fmt.Sprintf("`%s` CHAR(36) NOT NULL", UserIDColumn),
+	}
+	indexes := []string{
+		fmt.Sprintf("PRIMARY KEY (`%s`)", IDColumn),
+		fmt.Sprintf("UNIQUE KEY `uq_%[1]s` (`%[1]s`)", UserIDColumn),
+	}
+	for _, f := range fields {
+		columns = append(columns, f.columnDefinition())
+		if index := f.indexDefinition(); index != "" {
+			indexes = append(indexes, index)
+		}
+	}
+	return append(columns, indexes...)
+}
+
+// CreateTable returns the statement creating the user table of a client
+// in its database. The table is qualified with the database, since pooled
+// connections do not share a current database.
+func CreateTable(dbName string, fields []Field) string {
+	return "CREATE TABLE IF NOT EXISTS " + dbName + ".users (" + strings.Join(TableDefinitions(fields), ", ") + ")"
+}
+
+// columnDefinition returns the definition of the field's column.
+func (f Field) columnDefinition() string {
+	column := fmt.Sprintf("`%s` %s", f.Name, f.ColumnType())
+	if f.Required {
+		column += " NOT NULL"
+	}
+	if f.Default != nil {
+		column += " DEFAULT " + f.defaultLiteral()
+	}
+	return column
+}
+
+// indexName returns the name of the field's index, or an empty string if
+// it has none.
+func (f Field) indexName() string {
+	switch {
+	case f.Unique:
+		return "uq_" + f.Name
+	case f.Indexed:
+		return "idx_" + f.Name
+	}
+	return ""
+}
+
+// indexDefinition returns the definition of the field's index, or an empty
+// string if it has none.
+func (f Field) indexDefinition() string {
+	switch {
+	case f.Unique:
+		return fmt.Sprintf("UNIQUE KEY `%s` (`%s`)", f.indexName(), f.Name)
+	case f.Indexed:
+		return fmt.Sprintf("KEY `%s` (`%s`)", f.indexName(), f.Name)
+	}
+	return ""
+}
+
+// defaultLiteral renders the validated default as a SQL literal.
+func (f Field) defaultLiteral() string {
+	value := *f.Default
+	switch f.Type {
+	case Int, Float:
+		return value
+	case Bool:
+		b, _ := strconv.ParseBool(value)
+		return strings.ToUpper(strconv.FormatBool(b))
+	case Timestamp:
+		if value == "CURRENT_TIMESTAMP" {
+			return value
+		}
+		t, _ := time.Parse(time.RFC3339, value)
+		value = t.UTC().Format(time.DateTime)
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `''`).Replace(value) + "'"
+}
+
+// ValidateRecord checks user data against the fields of a client schema and
+// returns every violation. Unknown fields are rejected and empty values
+// count as absent.
+func ValidateRecord(fields []Field, data map[string]string) []Violation {
+	var violations []Violation
+	known := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		known[f.Name] = true
+		value, ok := data[f.Name]
+		switch {
+		case f.Deprecated:
+			if ok && value != "" {
+				violations = append(violations, Violation{Field: f.Name, Description: "field is deprecated"})
+			}
+		case !ok || value == "":
+			if f.Required && f.Default == nil {
+				violations = append(violations, Violation{Field: f.Name, Description: "field is required"})
+			}
+		default:
+			if description := f.CheckValue(value); description != "" {
+				violations = append(violations, Violation{Field: f.Name, Description: description})
+			}
+		}
+	}
+
+	var unknown []string
+	for name := range data {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		violations = append(violations, Violation{Field: name, Description: "field is not defined in the client schema"})
+	}
+	return violations
+}
+
+// SQLValue converts a value that passed CheckValue to the argument stored in
+// the field's column.
+func (f Field) SQLValue(value string) interface{} {
+	switch f.Type {
+	case Bool:
+		b, _ := strconv.ParseBool(value)
+		return b
+	case Timestamp:
+		t, _ := time.Parse(time.RFC3339, value)
+		return t.UTC()
+	}
+	return value
+}
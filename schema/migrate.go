@@ -0,0 +1,226 @@
+package schema
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ChangeKind is the kind of a schema change.
+type ChangeKind string
+
+const (
+	AddColumn    ChangeKind = "ADD_COLUMN"
+	WidenType    ChangeKind = "WIDEN_TYPE"
+	ChangeType   ChangeKind = "CHANGE_TYPE"
+	ModifyColumn ChangeKind = "MODIFY_COLUMN"
+	AddIndex     ChangeKind = "ADD_INDEX"
+	DropIndex    ChangeKind = "DROP_INDEX"
+	Deprecate    ChangeKind = "DEPRECATE_FIELD"
+	UpdateField  ChangeKind = "UPDATE_FIELD"
+	DropColumn   ChangeKind = "DROP_COLUMN"
+)
+
+// Change is one step of a schema migration. Clause is its part of the
+// ALTER TABLE statement on the user table, empty for changes that only
+// affect how values are validated.
+type Change struct {
+	Kind        ChangeKind `bson:"kind"`
+	Field       string     `bson:"field"`
+	Description string     `bson:"description"`
+	Destructive bool       `bson:"destructive"`
+	Clause      string     `bson:"clause,omitempty"`
+}
+
+// AlterTable returns the clauses of the changes, to be applied as one
+// ALTER TABLE statement.
+func AlterTable(changes []Change) []string {
+	var clauses []string
+	for _, c := range changes {
+		if c.Clause != "" {
+			clauses = append(clauses, c.Clause)
+		}
+	}
+	return clauses
+}
+
+// Diff returns the changes migrating a user table from the current fields to
+// the desired ones, which have passed ValidateFields. Columns are added,
+// modified, re-indexed and finally dropped. Dropping a column, changing a
+// type in a way that may lose data and making a field required are
+// destructive. Changes that cannot be applied to a table with users are
+// reported as violations.
+func Diff(current, desired []Field) ([]Change, []Violation) {
+	var violations []Violation
+	currentByName := make(map[string]Field, len(current))
+	for _, f := range current {
+		currentByName[strings.ToLower(f.Name)] = f
+	}
+	desiredByName := make(map[string]bool, len(desired))
+
+	var columns, indexes []Change
+	for _, f := range desired {
+		desiredByName[strings.ToLower(f.Name)] = true
+		old, ok := currentByName[strings.ToLower(f.Name)]
+		switch {
+		case !ok:
+			if f.Required && f.Default == nil {
+				violations = append(violations, Violation{Field: f.Name, Description: "new required fields need a default for existing users"})
+			}
+			if f.Required && f.Unique {
+				violations = append(violations, Violation{Field: f.Name, Description: "new unique fields cannot be required, since existing users would share a value"})
+			}
+			columns = append(columns, Change{
+				Kind:        AddColumn,
+				Field:       f.Name,
+				Description: fmt.Sprintf("add column %s %s", f.Name, f.ColumnType()),
+				Clause:      "ADD COLUMN " + f.columnDefinition(),
+			})
+			if index := f.indexDefinition(); index != "" {
+				indexes = append(indexes, Change{
+					Kind:        AddIndex,
+					Field:       f.Name,
+					Description: "add index " + f.indexName(),
+					Clause:      "ADD " + index,
+				})
+			}
+			continue
+		case old.Name != f.Name:
+			violations = append(violations, Violation{Field: f.Name, Description: fmt.Sprintf("renaming %q is not supported", old.Name)})
+			continue
+		}
+		columns = append(columns, columnChanges(old, f)...)
+		indexes = append(indexes, indexChanges(old, f)...)
+	}
+
+	var drops []Change
+	for _, f := range current {
+		if desiredByName[strings.ToLower(f.Name)] {
+			continue
+		}
+		drops = append(drops, Change{
+			Kind:        DropColumn,
+			Field:       f.Name,
+			Description: fmt.Sprintf("drop column %s and its data", f.Name),
+			Destructive: true,
+			Clause:      fmt.Sprintf("DROP COLUMN `%s`", f.Name),
+		})
+	}
+
+	changes := append(columns, indexes...)
+	return append(changes, drops...), violations
+}
+
+// columnChanges compares the column and validation of a field.
+func columnChanges(old, f Field) []Change {
+	var changes []Change
+	oldDefinition, definition := old.columnDefinition(), f.columnDefinition()
+	oldType, newType := old.ColumnType(), f.ColumnType()
+	switch {
+	case oldType != newType && widens(oldType, newType):
+		changes = append(changes, Change{
+			Kind:        WidenType,
+			Field:       f.Name,
+			Description: fmt.Sprintf("widen %s from %s to %s", f.Name, oldType, newType),
+		})
+	case oldType != newType:
+		changes = append(changes, Change{
+			Kind:        ChangeType,
+			Field:       f.Name,
+			Description: fmt.Sprintf("change %s from %s to %s, which may truncate or reject existing values", f.Name, oldType, newType),
+			Destructive: true,
+		})
+	case oldDefinition != definition && f.Required && !old.Required:
+		changes = append(changes, Change{
+			Kind:        ModifyColumn,
+			Field:       f.Name,
+			Description: fmt.Sprintf("make %s required, which fails if existing users have no value", f.Name),
+			Destructive: true,
+		})
+	case oldDefinition != definition:
+		changes = append(changes, Change{
+			Kind:        ModifyColumn,
+			Field:       f.Name,
+			Description: fmt.Sprintf("change the nullability or default of %s", f.Name),
+		})
+	}
+	if len(changes) > 0 {
+		changes[0].Clause = "MODIFY COLUMN " + definition
+		if changes[0].Kind != ModifyColumn && f.Required && !old.Required {
+			changes[0].Description += ", and make it required, which fails if existing users have no value"
+			changes[0].Destructive = true
+		}
+	}
+
+	switch {
+	case f.Deprecated && !old.Deprecated:
+		changes = append(changes, Change{Kind: Deprecate, Field: f.Name, Description: fmt.Sprintf("deprecate %s", f.Name)})
+	case old.Type != f.Type || old.Sensitive != f.Sensitive || old.Deprecated != f.Deprecated:
+		changes = append(changes, Change{Kind: UpdateField, Field: f.Name, Description: fmt.Sprintf("update the type or flags of %s", f.Name)})
+	}
+	return changes
+}
+
+// indexChanges compares the index of a field.
+func indexChanges(old, f Field) []Change {
+	oldName, name := old.indexName(), f.indexName()
+	if oldName == name {
+		return nil
+	}
+	var changes []Change
+	if oldName != "" {
+		changes = append(changes, Change{
+			Kind:        DropIndex,
+			Field:       f.Name,
+			Description: "drop index " + oldName,
+			Clause:      fmt.Sprintf("DROP INDEX `%s`", oldName),
+		})
+	}
+	if name != "" {
+		changes = append(changes, Change{
+			Kind:        AddIndex,
+			Field:       f.Name,
+			Description: "add index " + name,
+			Clause:      "ADD " + f.indexDefinition(),
+		})
+	}
+	return changes
+}
+
+// integerRanks orders the integer types by range.
+var integerRanks = map[string]int{"BOOLEAN": 1, "TINYINT": 1, "SMALLINT": 2, "MEDIUMINT": 3, "INT": 4, "BIGINT": 5}
+
+// widens reports whether every value of the MySQL type from is kept
+// unchanged by the type to.
+func widens(from, to string) bool {
+	fromBase, fromLength := splitType(from)
+	toBase, toLength := splitType(to)
+	isText := fromBase == "VARCHAR" || fromBase == "CHAR"
+	switch {
+	case isText && toBase == "VARCHAR":
+		return toLength >= fromLength
+	case isText && toBase == "TEXT":
+		return fromLength <= types[String].maxLength
+	case integerRanks[fromBase] > 0 && integerRanks[toBase] > 0:
+		return integerRanks[toBase] > integerRanks[fromBase]
+	case integerRanks[fromBase] > 0 && toBase == "DOUBLE":
+		// Doubles hold integers of up to 53 bits exactly
+		return integerRanks[fromBase] <= integerRanks["INT"]
+	case fromBase == "FLOAT" && toBase == "DOUBLE":
+		return true
+	case fromBase == "DATE" && toBase == "DATETIME":
+		return true
+	}
+	return false
+}
+
+// splitType splits a MySQL type into its name and length, if any.
+func splitType(sqlType string) (string, int) {
+	name, rest, ok := strings.Cut(sqlType, "(")
+	if !ok {
+		return sqlType, 0
+	}
+	digits, _, _ := strings.Cut(strings.TrimSuffix(rest, ")"), ",")
+	length, _ := strconv.Atoi(digits)
+	return name, length
+}
@@ -0,0 +1,171 @@
+package schema
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MaxFields is the largest number of fields a client schema may define.
+const MaxFields = 100
+
+// Columns of every user table besides the schema fields. Schemas cannot
+// define fields of these names.
+const (
+	IDColumn     = "id"      // surrogate primary key
+	UserIDColumn = "user_id" // server-generated UUID, the token subject
+)
+
+// Violation describes why a field of a client schema was rejected.
+type Violation struct {
+	Field       string
+	Description string
+}
+
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,63}$`)
+
+// typePattern matches a type name with optional size arguments, e.g.
+// VARCHAR(50) or DECIMAL(10, 2).
+var typePattern = regexp.MustCompile(`^([A-Z]+)\s*(?:\(\s*(\d+)\s*(?:,\s*(\d+)\s*)?\))?$`)
+
+// columnType describes a supported MySQL column type and the bounds of its
+// size arguments. Types without bounds take no arguments.
+type columnType struct {
+	minLength, maxLength int // VARCHAR and CHAR
+	maxPrecision         int // DECIMAL
+	maxScale             int
+}
+
+var columnTypes = map[string]columnType{
+	"VARCHAR":   {minLength: 1, maxLength: 16383},
+	"CHAR":      {minLength: 1, maxLength: 255},
+	"TEXT":      {},
+	"TINYINT":   {},
+	"SMALLINT":  {},
+	"INT":       {},
+	"BIGINT":    {},
+	"BOOLEAN":   {},
+	"DECIMAL":   {maxPrecision: 65, maxScale: 30},
+	"FLOAT":     {},
+	"DOUBLE":    {},
+	"DATE":      {},
+	"DATETIME":  {},
+	"TIMESTAMP": {},
+	"JSON":      {},
+}
+
+// aliases maps alternative spellings to the canonical type name.
+var aliases = map[string]string{
+	"BOOL":    "BOOLEAN",
+	"INTEGER": "INT",
+	"NUMERIC": "DECIMAL",
+}
+
+// Validate checks a client schema of field names to MySQL column types. It
+// returns the schema with canonical type names and every violation found;
+// the schema is only safe to turn into DDL when there are none.
+func Validate(fields map[string]string, primaryKeyField string) (map[string]string, []Violation) {
+	var violations []Violation
+	if len(fields) == 0 {
+		violations = append(violations, Violation{Field: "schema", Description: "schema must define at least one field"})
+	}
+	if len(fields) > MaxFields {
+		violations = append(violations, Violation{Field: "schema", Description: fmt.Sprintf("schema may define at most %d fields", MaxFields)})
+	}
+
+	// Walk the fields in order so that violations are reported stably
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	normalized := make(map[string]string, len(fields))
+	seen := map[string]string{}
+	for _, name := range names {
+		if description := checkIdentifier(name); description != "" {
+			violations = append(violations, Violation{Field: name, Description: description})
+		} else if other, ok := seen[strings.ToLower(name)]; ok {
+			violations = append(violations, Violation{Field: name, Description: fmt.Sprintf("field name conflicts with %q; column names are case-insensitive", other)})
+		}
+		seen[strings.ToLower(name)] = name
+
+		dataType, description := NormalizeType(fields[name])
+		if description != "" {
+			violations = append(violations, Violation{Field: name, Description: description})
+			continue
+		}
+		normalized[name] = dataType
+	}
+
+	switch _, ok := fields[primaryKeyField]; {
+	case primaryKeyField == "":
+		violations = append(violations, Violation{Field: "primary_key_field", Description: "primary key field is required"})
+	case !ok:
+		violations = append(violations, Violation{Field: "primary_key_field", Description: fmt.Sprintf("primary key field %q is not defined in the schema", primaryKeyField)})
+	}
+	return normalized, violations
+}
+
+// checkIdentifier returns why name cannot be used as a column name, or an
+// empty string if it can.
+func checkIdentifier(name string) string {
+	switch {
+	case !identifierPattern.MatchString(name):
+		return "field name must start with a letter or underscore, contain only letters, digits and underscores, and be at most 64 characters long"
+	case reserved[strings.ToUpper(name)]:
+		return fmt.Sprintf("%q is a reserved word", name)
+	case strings.EqualFold(name, IDColumn), strings.EqualFold(name, UserIDColumn):
+		return fmt.Sprintf("%q is reserved for a column of the service", name)
+	}
+	return ""
+}
+
+// NormalizeType checks a column type against the supported types and returns
+// its canonical spelling, or a description of why it is not supported.
+func NormalizeType(dataType string) (string, string) {
+	match := typePattern.FindStringSubmatch(strings.ToUpper(strings.TrimSpace(dataType)))
+	if match == nil {
+		return "", fmt.Sprintf("unsupported column type %q", dataType)
+	}
+	name, first, second := match[1], match[2], match[3]
+	if alias, ok := aliases[name]; ok {
+		name = alias
+	}
+	spec, ok := columnTypes[name]
+	if !ok {
+		return "", fmt.Sprintf("unsupported column type %q", dataType)
+	}
+
+	switch {
+	case spec.maxLength > 0:
+		length, _ := strconv.Atoi(first)
+		if first == "" || second != "" || length < spec.minLength || length > spec.maxLength {
+			return "", fmt.Sprintf("%s requires a length between %d and %d", name, spec.minLength, spec.maxLength)
+		}
+		return fmt.Sprintf("%s(%d)", name, length), ""
+	case spec.maxPrecision > 0:
+		if first == "" {
+			return "DECIMAL(10,0)", ""
+		}
+		precision, _ := strconv.Atoi(first)
+		scale, _ := strconv.Atoi(second)
+		if precision < 1 || precision > spec.maxPrecision || scale > spec.maxScale || scale > precision {
+			return "", fmt.Sprintf("DECIMAL requires a precision between 1 and %d and a scale between 0 and %d not above the precision", spec.maxPrecision, spec.maxScale)
+		}
+		return fmt.Sprintf("DECIMAL(%d,%d)", precision, scale), ""
+	case first != "":
+		return "", fmt.Sprintf("%s does not take a length", name)
+	}
+	return name, ""
+}
+
+func toSet(words string) map[string]bool {
+	set := map[string]bool{}
+	for _, word := range strings.Fields(words) {
+		set[word] = true
+	}
+	return set
+}
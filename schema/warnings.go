@@ -0,0 +1,49 @@
+package schema
+
+import (
+	"auth-service/password"
+	"fmt"
+)
+
+// Codes of schema warnings.
+const (
+	WarningMissingPassword    = "MISSING_PASSWORD_FIELD"
+	WarningNoUniqueIdentifier = "NO_UNIQUE_IDENTIFIER"
+	WarningOversizedColumn    = "OVERSIZED_COLUMN"
+)
+
+// Warning flags a valid schema that is likely not what its client wants.
+type Warning struct {
+	Field       string
+	Code        string
+	Description string
+}
+
+// largeColumnLength is the longest VARCHAR not worth storing as TEXT.
+const largeColumnLength = 4096
+
+// Warnings returns the warnings of fields that passed ValidateFields or
+// FromMap.
+func Warnings(fields []Field, primaryKeyField string) []Warning {
+	var warnings []Warning
+	hasPassword := false
+	for _, f := range fields {
+		if f.Name == password.Field {
+			hasPassword = true
+		}
+		if f.Name == primaryKeyField {
+			switch f.Type {
+			case Bool, Float, Date, Timestamp:
+				warnings = append(warnings, Warning{Field: f.Name, Code: WarningNoUniqueIdentifier, Description: fmt.Sprintf("%s values rarely tell users apart, and signups reusing a value fail", f.Type)})
+			}
+		}
+		name, length := splitType(f.ColumnType())
+		if name == "VARCHAR" && length > largeColumnLength && f.Name != password.Field {
+			warnings = append(warnings, Warning{Field: f.Name, Code: WarningOversizedColumn, Description: fmt.Sprintf("VARCHAR(%d) takes up to %d bytes of the row; TEXT suits long values better", length, 4*length)})
+		}
+	}
+	if !hasPassword {
+		warnings = append(warnings, Warning{Field: password.Field, Code: WarningMissingPassword, Description: "the schema has no password field, so users cannot log in"})
+	}
+	return warnings
+}
@@ -5,9 +5,12 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"os"
 	"testing"
+	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 )
 
 // var c pb.AuthServiceClient
@@ -30,19 +33,43 @@ func createConnection() (pb.AuthServiceClient, *grpc.ClientConn, error) {
 
 }
 
+// createAdminConnection connects to the AdminService, which is served on
+// ADMIN_GRPC_ADDR when the server was started with one
+func createAdminConnection() (pb.AdminServiceClient, *grpc.ClientConn, error) {
+	addr := os.Getenv("ADMIN_GRPC_ADDR")
+	if addr == "" {
+		addr = "localhost:50051"
+	}
+	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	if err != nil {
+		return nil, nil, err
+	}
+	return pb.NewAdminServiceClient(conn), conn, nil
+}
+
+// adminContext carries the bootstrap admin key the server was started with.
+// Admins may also call the tenant RPCs.
+func adminContext() context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "x-admin-key", os.Getenv("ADMIN_BOOTSTRAP_KEY"))
+}
+
+// testClientEmail is the email of the client registered by
+// TestGenerateClientID. Client emails are unique, so every run uses its own.
+var testClientEmail = fmt.Sprintf("integration_test_%d@example.com", time.Now().UnixNano())
+
 // Test GenerateClientID
 func TestGenerateClientID(t *testing.T) {
 	// Contact the server and print out its response.
-	c, conn, err := createConnection()
+	c, conn, err := createAdminConnection()
 	if err != nil {
 		t.Fatalf("did not connect: %v", err)
 	}
 	defer conn.Close()
 
-	response, err := c.GenerateClientID(context.Background(), &pb.GenerateClientRequest{
+	response, err := c.GenerateClientID(adminContext(), &pb.GenerateClientRequest{
 		Name:            "Test integartion Client",
 		Phone:           "1234567890",
-		Email:           "integaration_test@example.com",
+		Email:           testClientEmail,
 		Schema:          map[string]string{"username": "VARCHAR(50)", "password": "VARCHAR(50)"},
 		PrimaryKeyField: "username",
 	})
@@ -64,29 +91,26 @@ func TestGenerateClientID(t *testing.T) {
 
 }
 
-// Test GetClientID
-func TestGetClientID(t *testing.T) {
+// Test ListClientsForOwner
+func TestListClientsForOwner(t *testing.T) {
 	// Contact the server and print out its response.
-	c, conn, err := createConnection()
+	c, conn, err := createAdminConnection()
 	if err != nil {
 		t.Fatalf("did not connect: %v", err)
 	}
 	defer conn.Close()
 
-	req := &pb.GetClientRequest{Email: "integaration_test@example.com"}
-	resp, err := c.GetClientID(context.Background(), req)
+	// Clients registered without an owner are owned by their email
+	req := &pb.ListClientsForOwnerRequest{OwnerEmail: testClientEmail}
+	resp, err := c.ListClientsForOwner(adminContext(), req)
 	if err != nil {
-		t.Fatalf("GetClientID failed: %v", err)
+		t.Fatalf("ListClientsForOwner failed: %v", err)
 	}
 
-	if resp.ClientId == "" {
-		t.Errorf("expected non-empty ClientId, got empty")
-	}
-
-	if resp.Message != "Client ID retrieved successfully" {
-		t.Errorf("unexpected message: %s", resp.Message)
+	if len(resp.Clients) == 0 {
+		t.Errorf("expected at least one client, got none")
 	}
-	t.Logf("Client ID: %s", resp.ClientId)
+	t.Logf("Clients: %v", resp.Clients)
 }
 
 // Test Signup
@@ -105,7 +129,7 @@ func TestSignup(t *testing.T) {
 		PrimaryKeyField: "username",
 	}
 
-	resp, err := c.Signup(context.Background(), signupReq)
+	resp, err := c.Signup(adminContext(), signupReq)
 	if err != nil {
 		t.Fatalf("Signup failed: %v", err)
 	}
@@ -133,7 +157,7 @@ func TestLogin(t *testing.T) {
 		Password:        "newPassword",
 	}
 
-	resp, err := c.Login(context.Background(), loginReq)
+	resp, err := c.Login(adminContext(), loginReq)
 	if err != nil {
 		t.Fatalf("Login failed: %v", err)
 	}
@@ -142,7 +166,7 @@ func TestLogin(t *testing.T) {
 		t.Errorf("unexpected message: %s", resp.Message)
 	}
 
-	if resp.UserDetails["username"] != "newUser" || resp.UserDetails["password"] != "newPassword" {
+	if resp.UserDetails["username"] != "newUser" {
 		t.Errorf("unexpected user details: %v", resp.UserDetails)
 	}
 	t.Logf("User details: %v", resp.UserDetails)
@@ -1,16 +1,32 @@
 package handlers_test
 
 import (
+	"auth-service/apikey"
 	"auth-service/db"
 	"auth-service/handlers"
 	pb "auth-service/proto"
+	"auth-service/token"
+	"bytes"
 	"context"
+	"fmt"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/joho/godotenv"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+// Directory of the signing keys used by the tests
+var keystoreDir string
+
+// uniqueEmail returns an email no client registered by an earlier run uses
+func uniqueEmail(name string) string {
+	return fmt.Sprintf("%s+%d@example.com", name, time.Now().UnixNano())
+}
+
 // Setup Test Databases
 func init() {
 	// Load environment variables from .env file
@@ -28,16 +44,31 @@ func init() {
 	if err != nil {
 		panic("failed to connect to MySQL: " + err.Error())
 	}
+
+	keystoreDir, err = os.MkdirTemp("", "keystore")
+	if err != nil {
+		panic("failed to create keystore directory: " + err.Error())
+	}
+	err = token.Init(token.Config{
+		KeystoreDir:      keystoreDir,
+		Issuer:           "auth-service-test",
+		AccessTTL:        15 * time.Minute,
+		RefreshTTL:       24 * time.Hour,
+		KeyEncryptionKey: bytes.Repeat([]byte{0x42}, 32),
+	})
+	if err != nil {
+		panic("failed to load signing keys: " + err.Error())
+	}
 }
 
 // Test GenerateClientID
 func TestGenerateClientID(t *testing.T) {
-	server := &handlers.AuthServiceServer{}
+	server := &handlers.AdminServiceServer{}
 
 	req := &pb.GenerateClientRequest{
 		Name:            "Test Client 4",
 		Phone:           "1234567890",
-		Email:           "test4@example.com",
+		Email:           uniqueEmail("test4"),
 		Schema:          map[string]string{"username": "VARCHAR(50)", "password": "VARCHAR(50)"},
 		PrimaryKeyField: "username",
 	}
@@ -55,41 +86,104 @@ func TestGenerateClientID(t *testing.T) {
 	if resp.Message != "Client ID generated successfully" {
 		t.Errorf("unexpected message: %s", resp.Message)
 	}
+	if _, _, ok := apikey.Parse(apikey.ClientSecretPrefix, resp.ClientSecret); !ok {
+		t.Errorf("expected a client secret, got %q", resp.ClientSecret)
+	}
 	t.Logf("Client ID: %s", resp.ClientId)
 }
 
-// Test GetClientID
-func TestGetClientID(t *testing.T) {
-	server := &handlers.AuthServiceServer{}
-
-	// // First, create a new client to retrieve
-	// genReq := &pb.GenerateClientRequest{
-	// 	Name:            "Test Client",
-	// 	Phone:           "1234567890",
-	// 	Email:           "test2@example.com",
-	// 	Schema:          map[string]string{"username": "VARCHAR(50)", "password": "VARCHAR(50)"},
-	// 	PrimaryKeyField: "username",
-	// }
+// Test GenerateClientID with a typed schema
+func TestGenerateClientIDFields(t *testing.T) {
+	server := &handlers.AdminServiceServer{}
 
-	// _, err := server.GenerateClientID(context.Background(), genReq)
-	// if err != nil {
-	// 	t.Fatalf("failed to generate client ID for testing GetClientID: %v", err)
-	// }
+	req := &pb.GenerateClientRequest{
+		Name:  "Test Client Fields",
+		Phone: "1234567890",
+		Email: uniqueEmail("fields"),
+		Fields: []*pb.FieldSpec{
+			{Name: "email", Type: pb.FieldType_FIELD_TYPE_EMAIL, Unique: true},
+			{Name: "password", Type: pb.FieldType_FIELD_TYPE_STRING, Required: true},
+			{Name: "phone", Type: pb.FieldType_FIELD_TYPE_PHONE},
+		},
+		PrimaryKeyField: "email",
+	}
 
-	req := &pb.GetClientRequest{Email: "test4@example.com"}
-	resp, err := server.GetClientID(context.Background(), req)
+	resp, err := server.GenerateClientID(context.Background(), req)
 	if err != nil {
-		t.Fatalf("GetClientID failed: %v", err)
+		t.Fatalf("GenerateClientID failed: %v", err)
 	}
-
 	if resp.ClientId == "" {
 		t.Errorf("expected non-empty ClientId, got empty")
 	}
+}
 
-	if resp.Message != "Client ID retrieved successfully" {
-		t.Errorf("unexpected message: %s", resp.Message)
+// Test GenerateClientID with a signing key of the client's own
+func TestGenerateClientIDDedicatedSigningKey(t *testing.T) {
+	admin := &handlers.AdminServiceServer{}
+	server := &handlers.AuthServiceServer{}
+
+	req := &pb.GenerateClientRequest{
+		Name:                "Test Client 5",
+		Phone:               "1234567890",
+		Email:               uniqueEmail("test5"),
+		Schema:              map[string]string{"username": "VARCHAR(50)", "password": "VARCHAR(50)"},
+		PrimaryKeyField:     "username",
+		DedicatedSigningKey: true,
+		SigningAlgorithm:    token.EdDSA,
+	}
+	resp, err := admin.GenerateClientID(context.Background(), req)
+	if err != nil {
+		t.Fatalf("GenerateClientID failed: %v", err)
+	}
+
+	jwks, err := server.GetJWKS(context.Background(), &pb.GetJWKSRequest{ClientId: resp.ClientId})
+	if err != nil {
+		t.Fatalf("GetJWKS failed: %v", err)
+	}
+	if len(jwks.Keys) != 1 || jwks.Keys[0].Alg != token.EdDSA {
+		t.Errorf("expected the client's own key only, got %v", jwks.Keys)
+	}
+}
+
+// Test that an owner lists all of its clients and that client emails are
+// unique
+func TestListClientsForOwner(t *testing.T) {
+	server := &handlers.AdminServiceServer{}
+	ownerEmail := uniqueEmail("owner")
+
+	var clientEmails []string
+	for i := 0; i < 2; i++ {
+		email := uniqueEmail("owned")
+		_, err := server.GenerateClientID(context.Background(), &pb.GenerateClientRequest{
+			Name:            "Owned Client",
+			Email:           email,
+			OwnerEmail:      ownerEmail,
+			Schema:          map[string]string{"username": "VARCHAR(50)", "password": "VARCHAR(255)"},
+			PrimaryKeyField: "username",
+		})
+		if err != nil {
+			t.Fatalf("GenerateClientID failed: %v", err)
+		}
+		clientEmails = append(clientEmails, email)
+	}
+
+	resp, err := server.ListClientsForOwner(context.Background(), &pb.ListClientsForOwnerRequest{OwnerEmail: ownerEmail})
+	if err != nil {
+		t.Fatalf("ListClientsForOwner failed: %v", err)
+	}
+	if len(resp.Clients) != 2 || resp.Owner.Email != ownerEmail {
+		t.Errorf("expected the owner's two clients, got %v", resp.Clients)
+	}
+
+	_, err = server.GenerateClientID(context.Background(), &pb.GenerateClientRequest{
+		Name:            "Duplicate Client",
+		Email:           strings.ToUpper(clientEmails[0]),
+		Schema:          map[string]string{"username": "VARCHAR(50)", "password": "VARCHAR(255)"},
+		PrimaryKeyField: "username",
+	})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("expected AlreadyExists for a duplicate email, got %v", err)
 	}
-	t.Logf("Client ID: %s", resp.ClientId)
 }
 
 // Test Signup
@@ -114,6 +208,20 @@ func TestSignup(t *testing.T) {
 	t.Logf("Signup response: %s", resp.Message)
 }
 
+// Test that Signup rejects fields outside the client schema
+func TestSignupUnknownField(t *testing.T) {
+	server := &handlers.AuthServiceServer{}
+
+	signupReq := &pb.SignupRequest{
+		ClientId: "672e6755878f1dd94d4aa61d",
+		UserData: map[string]string{"username": "otherUser", "password": "newPassword", "is_admin": "1"},
+	}
+	_, err := server.Signup(context.Background(), signupReq)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument, got %v", err)
+	}
+}
+
 // Test Login
 func TestLogin(t *testing.T) {
 	server := &handlers.AuthServiceServer{}
@@ -135,8 +243,227 @@ func TestLogin(t *testing.T) {
 		t.Errorf("unexpected message: %s", resp.Message)
 	}
 
-	if resp.UserDetails["username"] != "newUser" || resp.UserDetails["password"] != "newPassword" {
+	if resp.UserDetails["username"] != "newUser" {
 		t.Errorf("unexpected user details: %v", resp.UserDetails)
 	}
+	if _, ok := resp.UserDetails["password"]; ok {
+		t.Errorf("expected the password to be left out of the user details")
+	}
+	if resp.UserAttributes.GetFields()["username"].GetStringValue() != "newUser" {
+		t.Errorf("unexpected user attributes: %v", resp.UserAttributes)
+	}
+
+	if resp.AccessToken == "" || resp.TokenType != "Bearer" || resp.ExpiresIn <= 0 {
+		t.Errorf("unexpected access token: %q %q %d", resp.AccessToken, resp.TokenType, resp.ExpiresIn)
+	}
+	if resp.RefreshToken == "" {
+		t.Errorf("expected non-empty RefreshToken, got empty")
+	}
+	if resp.UserId == "" {
+		t.Errorf("expected non-empty UserId, got empty")
+	}
 	t.Logf("User details: %v", resp.UserDetails)
 }
+
+// Test that Login only accepts the client's own primary key field
+func TestLoginPrimaryKeyField(t *testing.T) {
+	server := &handlers.AuthServiceServer{}
+
+	loginReq := &pb.LoginRequest{
+		ClientId:        "672e6755878f1dd94d4aa61d",
+		PrimaryKeyField: "password",
+		PrimaryKeyValue: "newPassword",
+		Password:        "newPassword",
+	}
+	if _, err := server.Login(context.Background(), loginReq); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for a mismatched primary key field, got %v", err)
+	}
+
+	loginReq = &pb.LoginRequest{
+		ClientId:        "client_1 WHERE 1=1; --",
+		PrimaryKeyField: "username",
+		PrimaryKeyValue: "newUser",
+		Password:        "newPassword",
+	}
+	if _, err := server.Login(context.Background(), loginReq); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for a malformed client ID, got %v", err)
+	}
+}
+
+// Test RefreshToken
+func TestRefreshToken(t *testing.T) {
+	server := &handlers.AuthServiceServer{}
+
+	// Assume that the user was added during the Signup test
+	loginReq := &pb.LoginRequest{
+		ClientId:        "672e6755878f1dd94d4aa61d",
+		PrimaryKeyField: "username",
+		PrimaryKeyValue: "newUser",
+		Password:        "newPassword",
+	}
+	resp, err := server.Login(context.Background(), loginReq)
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+
+	// Exchange the refresh token, then replay the old one
+	refreshReq := &pb.RefreshTokenRequest{ClientId: loginReq.ClientId, RefreshToken: resp.RefreshToken}
+	refreshed, err := server.RefreshToken(context.Background(), refreshReq)
+	if err != nil {
+		t.Fatalf("RefreshToken failed: %v", err)
+	}
+	if refreshed.AccessToken == "" || refreshed.RefreshToken == "" || refreshed.RefreshToken == resp.RefreshToken {
+		t.Errorf("unexpected refresh response: %v", refreshed)
+	}
+
+	if _, err := server.RefreshToken(context.Background(), refreshReq); err == nil {
+		t.Errorf("expected reuse of a rotated refresh token to fail")
+	}
+	refreshReq.RefreshToken = refreshed.RefreshToken
+	if _, err := server.RefreshToken(context.Background(), refreshReq); err == nil {
+		t.Errorf("expected the token family to be revoked after reuse")
+	}
+}
+
+// Test IntrospectToken
+func TestIntrospectToken(t *testing.T) {
+	server := &handlers.AuthServiceServer{}
+
+	// Assume that the user was added during the Signup test
+	loginReq := &pb.LoginRequest{
+		ClientId:        "672e6755878f1dd94d4aa61d",
+		PrimaryKeyField: "username",
+		PrimaryKeyValue: "newUser",
+		Password:        "newPassword",
+	}
+	loginResp, err := server.Login(context.Background(), loginReq)
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+
+	for _, tok := range []string{loginResp.AccessToken, loginResp.RefreshToken} {
+		resp, err := server.IntrospectToken(context.Background(), &pb.IntrospectTokenRequest{ClientId: loginReq.ClientId, Token: tok})
+		if err != nil {
+			t.Fatalf("IntrospectToken failed: %v", err)
+		}
+		if !resp.Active || resp.Sub != loginResp.UserId || resp.ClientId != loginReq.ClientId {
+			t.Errorf("unexpected introspection: %v", resp)
+		}
+	}
+
+	resp, err := server.IntrospectToken(context.Background(), &pb.IntrospectTokenRequest{ClientId: loginReq.ClientId, Token: "invalid"})
+	if err != nil {
+		t.Fatalf("IntrospectToken failed: %v", err)
+	}
+	if resp.Active {
+		t.Errorf("expected an unknown token to be inactive")
+	}
+}
+
+// Test Logout
+func TestLogout(t *testing.T) {
+	server := &handlers.AuthServiceServer{}
+
+	// Assume that the user was added during the Signup test
+	loginReq := &pb.LoginRequest{
+		ClientId:        "672e6755878f1dd94d4aa61d",
+		PrimaryKeyField: "username",
+		PrimaryKeyValue: "newUser",
+		Password:        "newPassword",
+	}
+	loginResp, err := server.Login(context.Background(), loginReq)
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+
+	resp, err := server.Logout(context.Background(), &pb.LogoutRequest{ClientId: loginReq.ClientId, RefreshToken: loginResp.RefreshToken})
+	if err != nil {
+		t.Fatalf("Logout failed: %v", err)
+	}
+	if resp.Message != "Logout successful" {
+		t.Errorf("unexpected message: %s", resp.Message)
+	}
+
+	// Both tokens of the session are revoked
+	for _, tok := range []string{loginResp.AccessToken, loginResp.RefreshToken} {
+		if _, err := server.ValidateToken(context.Background(), &pb.ValidateTokenRequest{ClientId: loginReq.ClientId, Token: tok}); err == nil {
+			t.Errorf("expected token to be revoked after logout")
+		}
+	}
+}
+
+// Test RevokeAllSessions
+func TestRevokeAllSessions(t *testing.T) {
+	server := &handlers.AuthServiceServer{}
+
+	// Assume that the user was added during the Signup test
+	loginReq := &pb.LoginRequest{
+		ClientId:        "672e6755878f1dd94d4aa61d",
+		PrimaryKeyField: "username",
+		PrimaryKeyValue: "newUser",
+		Password:        "newPassword",
+	}
+	loginResp, err := server.Login(context.Background(), loginReq)
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+
+	resp, err := server.RevokeAllSessions(context.Background(), &pb.RevokeAllSessionsRequest{ClientId: loginReq.ClientId, Subject: loginResp.UserId})
+	if err != nil {
+		t.Fatalf("RevokeAllSessions failed: %v", err)
+	}
+	if resp.RevokedSessions < 1 {
+		t.Errorf("expected at least one revoked session, got %d", resp.RevokedSessions)
+	}
+
+	if _, err := server.ValidateToken(context.Background(), &pb.ValidateTokenRequest{ClientId: loginReq.ClientId, Token: loginResp.AccessToken}); err == nil {
+		t.Errorf("expected access token to be revoked")
+	}
+
+	// Logging in again, typically within the same second, starts a session
+	// the revocation does not cover
+	loginResp, err = server.Login(context.Background(), loginReq)
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if _, err := server.ValidateToken(context.Background(), &pb.ValidateTokenRequest{ClientId: loginReq.ClientId, Token: loginResp.AccessToken}); err != nil {
+		t.Errorf("expected the access token of a later login to be valid, got %v", err)
+	}
+}
+
+// Test that malformed client IDs are reported per client and never reach a
+// query
+func TestLegacyCredentialReportInvalidClientID(t *testing.T) {
+	admin := &handlers.AdminServiceServer{}
+
+	resp, err := admin.GetLegacyCredentialReport(context.Background(), &pb.LegacyCredentialReportRequest{
+		ClientIds: []string{"x.users; DROP DATABASE mysql; --"},
+	})
+	if err != nil {
+		t.Fatalf("GetLegacyCredentialReport failed: %v", err)
+	}
+	if len(resp.Reports) != 1 || resp.Reports[0].Error != handlers.ReasonInvalidClientID {
+		t.Errorf("expected an INVALID_CLIENT_ID report, got %v", resp.Reports)
+	}
+}
+
+// Test that token RPCs on an unregistered client fail with NotFound rather
+// than reaching its missing database
+func TestTokenRPCsUnknownClient(t *testing.T) {
+	server := &handlers.AuthServiceServer{}
+	ctx := context.Background()
+	clientID := "0123456789abcdef01234567"
+
+	_, err := server.ValidateToken(ctx, &pb.ValidateTokenRequest{ClientId: clientID, Token: "token"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("ValidateToken: expected NotFound, got %v", err)
+	}
+	_, err = server.IntrospectToken(ctx, &pb.IntrospectTokenRequest{ClientId: clientID, Token: "token"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("IntrospectToken: expected NotFound, got %v", err)
+	}
+	_, err = server.RefreshToken(ctx, &pb.RefreshTokenRequest{ClientId: clientID, RefreshToken: "token"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("RefreshToken: expected NotFound, got %v", err)
+	}
+}
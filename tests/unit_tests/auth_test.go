@@ -0,0 +1,99 @@
+package handlers_test
+
+import (
+	"auth-service/apikey"
+	"auth-service/handlers"
+	pb "auth-service/proto"
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Test that API keys round-trip through Parse and only match their hash
+func TestAPIKey(t *testing.T) {
+	key, id, hash, err := apikey.Generate(apikey.AdminPrefix)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	parsedID, secret, ok := apikey.Parse(apikey.AdminPrefix, key)
+	if !ok || parsedID != id {
+		t.Fatalf("failed to parse %q", key)
+	}
+	if !apikey.Matches(secret, hash) || apikey.Matches(secret+"x", hash) {
+		t.Errorf("unexpected match result")
+	}
+	if _, _, ok := apikey.Parse("other", key); ok {
+		t.Errorf("expected a key with another prefix to be rejected")
+	}
+}
+
+// Test that AdminService RPCs require admin credentials
+func TestAuthInterceptor(t *testing.T) {
+	handlers.SetBootstrapAdminKey("bootstrap-secret")
+	defer handlers.SetBootstrapAdminKey("")
+
+	called := false
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		called = true
+		return nil, nil
+	}
+	call := func(method, key string) error {
+		called = false
+		ctx := context.Background()
+		if key != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(handlers.AdminKeyHeader, key))
+		}
+		_, err := handlers.AuthInterceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		return err
+	}
+
+	if err := call(pb.AdminService_GenerateClientID_FullMethodName, ""); status.Code(err) != codes.Unauthenticated || called {
+		t.Errorf("expected Unauthenticated without credentials, got %v", err)
+	}
+	if err := call(pb.AdminService_GenerateClientID_FullMethodName, "adm_malformed"); status.Code(err) != codes.Unauthenticated || called {
+		t.Errorf("expected Unauthenticated for a malformed key, got %v", err)
+	}
+	if err := call(pb.AdminService_GenerateClientID_FullMethodName, "bootstrap-secret"); err != nil || !called {
+		t.Errorf("expected the bootstrap key to be accepted, got %v", err)
+	}
+	if err := call(pb.AuthService_GetJWKS_FullMethodName, ""); err != nil || !called {
+		t.Errorf("expected GetJWKS to stay public, got %v", err)
+	}
+}
+
+// Test that tenant RPCs require client credentials and that API keys cannot
+// manage keys
+func TestAuthInterceptorTenant(t *testing.T) {
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		t.Errorf("handler called without valid credentials")
+		return nil, nil
+	}
+	call := func(method string, req interface{}, pairs ...string) error {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(pairs...))
+		_, err := handlers.AuthInterceptor(ctx, req, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		return err
+	}
+	login := &pb.LoginRequest{ClientId: "672e6755878f1dd94d4aa61d"}
+	createKey := &pb.CreateApiKeyRequest{ClientId: "672e6755878f1dd94d4aa61d", Name: "signup"}
+
+	if err := call(pb.AuthService_Login_FullMethodName, login); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated without credentials, got %v", err)
+	}
+	if err := call(pb.AuthService_Login_FullMethodName, login, handlers.APIKeyHeader, "tk_malformed"); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated for a malformed API key, got %v", err)
+	}
+	if err := call(pb.AuthService_Login_FullMethodName, &pb.LoginRequest{ClientId: "client_1"}, handlers.APIKeyHeader, "tk_malformed"); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for an invalid client ID, got %v", err)
+	}
+	key, _, _, err := apikey.Generate(apikey.TenantPrefix)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if err := call(pb.AuthService_CreateApiKey_FullMethodName, createKey, handlers.APIKeyHeader, key); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected API keys to be rejected for key management, got %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package handlers_test
+
+import (
+	"auth-service/handlers"
+	pb "auth-service/proto"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errorReason returns the reason of the ErrorInfo detail of err
+func errorReason(err error) string {
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok {
+			return info.Reason
+		}
+	}
+	return ""
+}
+
+// Test that handler errors are mapped to status codes without their causes
+func TestErrorInterceptor(t *testing.T) {
+	tests := []struct {
+		err    error
+		code   codes.Code
+		reason string
+	}{
+		{
+			fmt.Errorf("failed to insert user: %w", &mysql.MySQLError{Number: 1064, Message: "You have an error in your SQL syntax near 'DROP TABLE users'"}),
+			codes.Internal, handlers.ReasonInternal,
+		},
+		{
+			fmt.Errorf("failed to insert user: %w", &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'a' for key 'users.uq_email'"}),
+			codes.AlreadyExists, handlers.ReasonAlreadyExists,
+		},
+		{
+			fmt.Errorf("failed to execute login query: %w", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}),
+			codes.Unavailable, handlers.ReasonUnavailable,
+		},
+		{
+			&handlers.Error{Code: codes.Unauthenticated, Reason: handlers.ReasonInvalidCredentials, Message: "invalid credentials"},
+			codes.Unauthenticated, handlers.ReasonInvalidCredentials,
+		},
+	}
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/auth.AuthService/Test"}
+	for _, tt := range tests {
+		_, err := handlers.ErrorInterceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, tt.err
+		})
+		st := status.Convert(err)
+		if st.Code() != tt.code || errorReason(err) != tt.reason {
+			t.Errorf("%v: expected %v %s, got %v %s", tt.err, tt.code, tt.reason, st.Code(), errorReason(err))
+		}
+		if strings.Contains(st.Message(), "SQL") || strings.Contains(st.Message(), "Duplicate entry") || strings.Contains(st.Message(), "dial") {
+			t.Errorf("status message leaks the cause: %q", st.Message())
+		}
+	}
+}
+
+// Test that malformed client IDs are rejected before any query
+func TestInvalidClientID(t *testing.T) {
+	server := &handlers.AuthServiceServer{}
+
+	_, err := server.ValidateToken(context.Background(), &pb.ValidateTokenRequest{ClientId: "x; DROP DATABASE y", Token: "token"})
+	if status.Code(err) != codes.InvalidArgument || errorReason(err) != handlers.ReasonInvalidClientID {
+		t.Errorf("expected InvalidArgument %s, got %v", handlers.ReasonInvalidClientID, err)
+	}
+}
@@ -0,0 +1,195 @@
+package handlers_test
+
+import (
+	"auth-service/db"
+	"auth-service/handlers"
+	pb "auth-service/proto"
+	"auth-service/schema"
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Test that a malformed page token is rejected before any query
+func TestListClientsInvalidPageToken(t *testing.T) {
+	admin := &handlers.AdminServiceServer{}
+
+	_, err := admin.ListClients(context.Background(), &pb.ListClientsRequest{PageToken: "not a token"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument, got %v", err)
+	}
+}
+
+// Test describing, updating, listing, deleting and restoring a client
+func TestClientLifecycle(t *testing.T) {
+	admin := &handlers.AdminServiceServer{}
+	server := &handlers.AuthServiceServer{}
+	ctx := context.Background()
+
+	email := uniqueEmail("lifecycle")
+	created, err := admin.GenerateClientID(ctx, &pb.GenerateClientRequest{
+		Name:            "Lifecycle Client",
+		Email:           email,
+		Schema:          map[string]string{"username": "VARCHAR(50)", "password": "VARCHAR(255)"},
+		PrimaryKeyField: "username",
+	})
+	if err != nil {
+		t.Fatalf("GenerateClientID failed: %v", err)
+	}
+
+	described, err := admin.DescribeClient(ctx, &pb.DescribeClientRequest{ClientId: created.ClientId})
+	if err != nil {
+		t.Fatalf("DescribeClient failed: %v", err)
+	}
+	if c := described.Client; c.Name != "Lifecycle Client" || c.Status != pb.ClientStatus_CLIENT_STATUS_READY || len(c.Fields) != 2 || c.CreatedAt == 0 {
+		t.Errorf("unexpected client: %v", c)
+	}
+
+	phone := "5550100"
+	updated, err := admin.UpdateClient(ctx, &pb.UpdateClientRequest{ClientId: created.ClientId, Phone: &phone})
+	if err != nil {
+		t.Fatalf("UpdateClient failed: %v", err)
+	}
+	if updated.Client.Phone != phone || updated.Client.Name != "Lifecycle Client" {
+		t.Errorf("expected only the phone to change, got %v", updated.Client)
+	}
+	_, err = admin.UpdateClient(ctx, &pb.UpdateClientRequest{
+		ClientId:      created.ClientId,
+		ProfileFields: &pb.ProfileFieldList{Names: []string{"password"}},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected sensitive profile fields to be rejected, got %v", err)
+	}
+
+	listed, err := admin.ListClients(ctx, &pb.ListClientsRequest{Email: email, PageSize: 1})
+	if err != nil {
+		t.Fatalf("ListClients failed: %v", err)
+	}
+	if len(listed.Clients) != 1 {
+		t.Errorf("expected a page of one client, got %d", len(listed.Clients))
+	}
+
+	_, err = admin.DeleteClient(ctx, &pb.DeleteClientRequest{ClientId: created.ClientId})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected deletion without confirmation to be rejected, got %v", err)
+	}
+	deleted, err := admin.DeleteClient(ctx, &pb.DeleteClientRequest{ClientId: created.ClientId, Confirm: created.ClientId})
+	if err != nil {
+		t.Fatalf("DeleteClient failed: %v", err)
+	}
+	if deleted.PurgeAfter == 0 {
+		t.Errorf("expected a purge time")
+	}
+	_, err = server.Login(ctx, &pb.LoginRequest{ClientId: created.ClientId, PrimaryKeyValue: "user", Password: "password"})
+	if errorReason(err) != handlers.ReasonClientPendingDeletion {
+		t.Errorf("expected clients pending deletion to be unusable, got %v", err)
+	}
+
+	restored, err := admin.RestoreClient(ctx, &pb.RestoreClientRequest{ClientId: created.ClientId})
+	if err != nil {
+		t.Fatalf("RestoreClient failed: %v", err)
+	}
+	if restored.Client.Status != pb.ClientStatus_CLIENT_STATUS_READY || restored.Client.PurgeAfter != 0 {
+		t.Errorf("unexpected restored client: %v", restored.Client)
+	}
+}
+
+// Test that a client abandoned mid-provisioning is provisioned by the
+// reconciler
+func TestReconcileClients(t *testing.T) {
+	admin := &handlers.AdminServiceServer{}
+	ctx := context.Background()
+
+	id := primitive.NewObjectID()
+	startedAt := time.Now().UTC().Add(-time.Hour)
+	_, err := db.GetClientsCollection().InsertOne(ctx, bson.M{
+		"_id":               id,
+		"name":              "Abandoned Client",
+		"fields":            []schema.Field{{Name: "username", Type: schema.String, Required: true, Unique: true}},
+		"primary_key_field": "username",
+		"status":            "PROVISIONING",
+		"created_at":        startedAt,
+		"provisioning":      bson.M{"attempts": 1, "started_at": startedAt},
+	})
+	if err != nil {
+		t.Fatalf("failed to insert client: %v", err)
+	}
+
+	if err := handlers.ReconcileClients(ctx); err != nil {
+		t.Fatalf("ReconcileClients failed: %v", err)
+	}
+	described, err := admin.DescribeClient(ctx, &pb.DescribeClientRequest{ClientId: id.Hex()})
+	if err != nil {
+		t.Fatalf("DescribeClient failed: %v", err)
+	}
+	if described.Client.Status != pb.ClientStatus_CLIENT_STATUS_READY {
+		t.Errorf("expected the client to be ready, got %v (%s)", described.Client.Status, described.Client.StatusDetail)
+	}
+}
+
+// Test a dry run, a refused destructive change and an applied migration of
+// a client schema
+func TestUpdateClientSchema(t *testing.T) {
+	admin := &handlers.AdminServiceServer{}
+	ctx := context.Background()
+
+	fields := []*pb.FieldSpec{
+		{Name: "email", Type: pb.FieldType_FIELD_TYPE_EMAIL},
+		{Name: "password", Type: pb.FieldType_FIELD_TYPE_STRING},
+		{Name: "nickname", Type: pb.FieldType_FIELD_TYPE_STRING, MaxLength: 50},
+	}
+	created, err := admin.GenerateClientID(ctx, &pb.GenerateClientRequest{
+		Name:            "Migrating Client",
+		Email:           uniqueEmail("migrations"),
+		Fields:          fields,
+		PrimaryKeyField: "email",
+	})
+	if err != nil {
+		t.Fatalf("GenerateClientID failed: %v", err)
+	}
+
+	desired := []*pb.FieldSpec{
+		fields[0],
+		fields[1],
+		{Name: "nickname", Type: pb.FieldType_FIELD_TYPE_STRING, MaxLength: 100, Indexed: true},
+		{Name: "country", Type: pb.FieldType_FIELD_TYPE_STRING, MaxLength: 2},
+	}
+	dryRun, err := admin.UpdateClientSchema(ctx, &pb.UpdateClientSchemaRequest{ClientId: created.ClientId, Fields: desired, DryRun: true})
+	if err != nil {
+		t.Fatalf("UpdateClientSchema dry run failed: %v", err)
+	}
+	if len(dryRun.Changes) != 3 || dryRun.MigrationId != "" || dryRun.Client.SchemaVersion != 1 {
+		t.Errorf("unexpected dry run: %v", dryRun)
+	}
+
+	_, err = admin.UpdateClientSchema(ctx, &pb.UpdateClientSchemaRequest{ClientId: created.ClientId, Fields: fields[:2]})
+	if errorReason(err) != handlers.ReasonDestructiveChange {
+		t.Errorf("expected dropping a column to be refused, got %v", err)
+	}
+
+	migrated, err := admin.UpdateClientSchema(ctx, &pb.UpdateClientSchemaRequest{ClientId: created.ClientId, Fields: desired, ExpectedVersion: 1})
+	if err != nil {
+		t.Fatalf("UpdateClientSchema failed: %v", err)
+	}
+	if migrated.ToVersion != 2 || migrated.Client.SchemaVersion != 2 || len(migrated.Client.Fields) != 4 || migrated.MigrationId == "" {
+		t.Errorf("unexpected migration: %v", migrated)
+	}
+	var columns int
+	err = db.MySQLClient.QueryRow(
+		"SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = ? AND TABLE_NAME = 'users' AND COLUMN_NAME = 'country'",
+		"client_"+created.ClientId,
+	).Scan(&columns)
+	if err != nil || columns != 1 {
+		t.Errorf("expected the country column to exist, got %d (%v)", columns, err)
+	}
+
+	_, err = admin.UpdateClientSchema(ctx, &pb.UpdateClientSchemaRequest{ClientId: created.ClientId, Fields: desired, ExpectedVersion: 1})
+	if errorReason(err) != handlers.ReasonSchemaVersionConflict {
+		t.Errorf("expected a stale version to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package handlers_test
+
+import (
+	"auth-service/db"
+	"errors"
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Test that duplicate key errors are recognized with the violated index
+func TestDuplicateKey(t *testing.T) {
+	err := fmt.Errorf("insert failed: %w", &mysql.MySQLError{
+		Number:  db.ErDupEntry,
+		Message: "Duplicate entry 'x for key 'a'' for key 'users.uq_email'",
+	})
+	if key, ok := db.DuplicateKey(err); !ok || key != "uq_email" {
+		t.Errorf("expected uq_email, got %q %v", key, ok)
+	}
+
+	if _, ok := db.DuplicateKey(errors.New("connection refused")); ok {
+		t.Errorf("unexpected duplicate key for an unrelated error")
+	}
+}
+
+// Test that user IDs are distinct, time-ordered UUIDv7s
+func TestNewUserID(t *testing.T) {
+	pattern := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+	first, err := db.NewUserID()
+	if err != nil {
+		t.Fatalf("NewUserID failed: %v", err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	second, err := db.NewUserID()
+	if err != nil {
+		t.Fatalf("NewUserID failed: %v", err)
+	}
+	for _, id := range []string{first, second} {
+		if !pattern.MatchString(id) {
+			t.Errorf("%q is not a UUIDv7", id)
+		}
+	}
+	if first >= second {
+		t.Errorf("expected %q to sort before %q", first, second)
+	}
+}
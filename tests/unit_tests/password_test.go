@@ -0,0 +1,126 @@
+package handlers_test
+
+import (
+	"auth-service/password"
+	"strings"
+	"testing"
+)
+
+// Test hashing and verifying with every supported algorithm
+func TestPasswordHashVerify(t *testing.T) {
+	for _, alg := range []password.Algorithm{password.Argon2id, password.Bcrypt, password.Scrypt} {
+		policy := password.DefaultPolicy
+		policy.Algorithm = alg
+		policy.Cost = 4 // keep bcrypt fast in tests
+
+		hash, err := policy.Hash("newPassword")
+		if err != nil {
+			t.Fatalf("%s: Hash failed: %v", alg, err)
+		}
+		if strings.Contains(hash, "newPassword") {
+			t.Fatalf("%s: hash contains the plaintext password: %s", alg, hash)
+		}
+
+		ok, err := password.Verify("newPassword", hash)
+		if err != nil || !ok {
+			t.Errorf("%s: expected password to verify, got ok=%v err=%v", alg, ok, err)
+		}
+		ok, err = password.Verify("wrongPassword", hash)
+		if err != nil || ok {
+			t.Errorf("%s: expected wrong password to be rejected, got ok=%v err=%v", alg, ok, err)
+		}
+	}
+}
+
+// Test that the parameters encoded in the hash are used for verification
+func TestPasswordVerifyUsesEncodedParameters(t *testing.T) {
+	policy := password.DefaultPolicy
+	policy.Memory = 8 * 1024
+	policy.Iterations = 1
+	hash, err := policy.Hash("newPassword")
+	if err != nil {
+		t.Fatalf("Hash failed: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=8192,t=1,p=2$") {
+		t.Errorf("unexpected hash encoding: %s", hash)
+	}
+
+	ok, err := password.Verify("newPassword", hash)
+	if err != nil || !ok {
+		t.Errorf("expected password to verify, got ok=%v err=%v", ok, err)
+	}
+}
+
+// Test that values which are not hashes are rejected
+func TestPasswordVerifyUnknownFormat(t *testing.T) {
+	if _, err := password.Verify("newPassword", "newPassword"); err != password.ErrUnknownFormat {
+		t.Errorf("expected ErrUnknownFormat, got %v", err)
+	}
+}
+
+// Test that legacy plaintext and outdated hashes are flagged for rehashing
+func TestPasswordCheckRehash(t *testing.T) {
+	ok, rehash, err := password.Check("newPassword", "newPassword")
+	if err != nil || !ok || !rehash {
+		t.Errorf("legacy plaintext: expected ok and rehash, got ok=%v rehash=%v err=%v", ok, rehash, err)
+	}
+	ok, rehash, _ = password.Check("wrongPassword", "newPassword")
+	if ok || rehash {
+		t.Errorf("legacy plaintext: expected wrong password to be rejected, got ok=%v rehash=%v", ok, rehash)
+	}
+
+	weak := password.DefaultPolicy
+	weak.Memory = 8 * 1024
+	weakHash, err := weak.Hash("newPassword")
+	if err != nil {
+		t.Fatalf("Hash failed: %v", err)
+	}
+	if status := password.Classify(weakHash); status != password.StatusOutdated {
+		t.Errorf("expected outdated hash, got %s", status)
+	}
+	ok, rehash, err = password.Check("newPassword", weakHash)
+	if err != nil || !ok || !rehash {
+		t.Errorf("outdated hash: expected ok and rehash, got ok=%v rehash=%v err=%v", ok, rehash, err)
+	}
+
+	hash, err := password.Hash("newPassword")
+	if err != nil {
+		t.Fatalf("Hash failed: %v", err)
+	}
+	if status := password.Classify(hash); status != password.StatusCurrent {
+		t.Errorf("expected current hash, got %s", status)
+	}
+	ok, rehash, err = password.Check("newPassword", hash)
+	if err != nil || !ok || rehash {
+		t.Errorf("current hash: expected ok without rehash, got ok=%v rehash=%v err=%v", ok, rehash, err)
+	}
+}
+
+// Test that the dummy verification for unknown users matches no password and
+// checks a hash made once under the current policy
+func TestPasswordVerifyDummy(t *testing.T) {
+	defer func(policy password.Policy) { password.Current = policy }(password.Current)
+
+	if password.VerifyDummy("password") || password.VerifyDummy("") {
+		t.Errorf("expected no password to match the dummy hash")
+	}
+	first, err := password.DummyHash()
+	if err != nil {
+		t.Fatalf("DummyHash failed: %v", err)
+	}
+	if second, _ := password.DummyHash(); second != first {
+		t.Errorf("expected the dummy hash to be made once")
+	}
+	if status := password.Current.Classify(first); status != password.StatusCurrent {
+		t.Errorf("expected the dummy hash to use the current policy, got status %v", status)
+	}
+
+	password.Current.Algorithm, password.Current.Cost = password.Bcrypt, 4
+	rehashed, err := password.DummyHash()
+	if err != nil {
+		t.Fatalf("DummyHash failed: %v", err)
+	}
+	if rehashed == first || password.Current.Classify(rehashed) != password.StatusCurrent {
+		t.Errorf("expected a new dummy hash under the changed policy, got %q", rehashed)
+	}
+}
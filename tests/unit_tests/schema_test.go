@@ -0,0 +1,467 @@
+package handlers_test
+
+import (
+	"auth-service/handlers"
+	pb "auth-service/proto"
+	"auth-service/schema"
+	"context"
+	"strings"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Test that a well-formed schema is accepted and normalized
+func TestValidateSchema(t *testing.T) {
+	fields := map[string]string{
+		"username":   "varchar(50)",
+		"password":   "VARCHAR(50)",
+		"age":        "integer",
+		"balance":    "DECIMAL(10, 2)",
+		"verified":   "bool",
+		"created_at": "TIMESTAMP",
+	}
+	normalized, violations := schema.Validate(fields, "username")
+	if len(violations) != 0 {
+		t.Fatalf("unexpected violations: %v", violations)
+	}
+
+	want := map[string]string{
+		"username":   "VARCHAR(50)",
+		"password":   "VARCHAR(50)",
+		"age":        "INT",
+		"balance":    "DECIMAL(10,2)",
+		"verified":   "BOOLEAN",
+		"created_at": "TIMESTAMP",
+	}
+	for field, dataType := range want {
+		if normalized[field] != dataType {
+			t.Errorf("%s: expected %s, got %s", field, dataType, normalized[field])
+		}
+	}
+}
+
+// Test that unsafe names and types are rejected
+func TestValidateSchemaViolations(t *testing.T) {
+	fields := map[string]string{
+		"username":                    "VARCHAR(50)",
+		"x INT); DROP DATABASE a; --": "INT",
+		"select":                      "INT",
+		"bio":                         "TEXT; DROP TABLE users",
+		"nickname":                    "VARCHAR(70000)",
+		"code":                        "CHAR",
+		"age":                         "INT(11)",
+		"price":                       "DECIMAL(10,20)",
+	}
+	_, violations := schema.Validate(fields, "email")
+
+	rejected := map[string]bool{}
+	for _, v := range violations {
+		rejected[v.Field] = true
+	}
+	for _, field := range []string{"x INT); DROP DATABASE a; --", "select", "bio", "nickname", "code", "age", "price", "primary_key_field"} {
+		if !rejected[field] {
+			t.Errorf("expected a violation for %q", field)
+		}
+	}
+	if rejected["username"] {
+		t.Errorf("unexpected violation for username")
+	}
+}
+
+// Test that GenerateClientID rejects an invalid schema with field violations
+func TestGenerateClientIDInvalidSchema(t *testing.T) {
+	server := &handlers.AdminServiceServer{}
+
+	req := &pb.GenerateClientRequest{
+		Name:            "Test Client",
+		Phone:           "1234567890",
+		Email:           "invalid_schema@example.com",
+		Schema:          map[string]string{"username": "VARCHAR(50)", "password": "VARCHAR(50)) ; DROP TABLE users; --"},
+		PrimaryKeyField: "username",
+	}
+	_, err := server.GenerateClientID(context.Background(), req)
+
+	st := status.Convert(err)
+	if st.Code() != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument, got %v", err)
+	}
+	var violations []*errdetails.BadRequest_FieldViolation
+	for _, detail := range st.Details() {
+		if badRequest, ok := detail.(*errdetails.BadRequest); ok {
+			violations = append(violations, badRequest.FieldViolations...)
+		}
+	}
+	if len(violations) != 1 || violations[0].Field != "schema.password" {
+		t.Errorf("unexpected field violations: %v", violations)
+	}
+}
+
+// Test that typed fields keep their order and become the expected DDL
+func TestValidateFields(t *testing.T) {
+	active := "true"
+	fields, violations := schema.ValidateFields([]schema.Field{
+		{Name: "email", Type: schema.Email},
+		{Name: "password", Type: schema.String, MaxLength: 20},
+		{Name: "nickname", Type: schema.String, MaxLength: 30, Indexed: true},
+		{Name: "active", Type: schema.Bool, Default: &active},
+		{Name: "profile", Type: schema.JSON},
+	}, "email")
+	if len(violations) != 0 {
+		t.Fatalf("unexpected violations: %v", violations)
+	}
+	if !fields[0].Required || !fields[0].Unique {
+		t.Errorf("expected the primary key field to be required and unique")
+	}
+	if !fields[1].Sensitive || fields[1].ColumnType() != "VARCHAR(255)" {
+		t.Errorf("expected a sensitive VARCHAR(255) password column, got %+v", fields[1])
+	}
+
+	want := []string{
+		"`id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT",
+		"`user_id` CHAR(36) NOT NULL",
+		"`email` VARCHAR(254) NOT NULL",
+		"`password` VARCHAR(255)",
+		"`nickname` VARCHAR(30)",
+		"`active` BOOLEAN DEFAULT TRUE",
+		"`profile` JSON",
+		"PRIMARY KEY (`id`)",
+		"UNIQUE KEY `uq_user_id` (`user_id`)",
+		"UNIQUE KEY `uq_email` (`email`)",
+		"KEY `idx_nickname` (`nickname`)",
+	}
+	definitions := schema.TableDefinitions(fields)
+	if strings.Join(definitions, ", ") != strings.Join(want, ", ") {
+		t.Errorf("expected %v, got %v", want, definitions)
+	}
+}
+
+// Test that invalid typed fields are rejected
+func TestValidateFieldsViolations(t *testing.T) {
+	bad := "not-a-number"
+	_, violations := schema.ValidateFields([]schema.Field{
+		{Name: "username", Type: schema.String, MaxLength: 50},
+		{Name: "Username", Type: schema.String},
+		{Name: "bio", Type: schema.Text, Unique: true},
+		{Name: "age", Type: schema.Int, MaxLength: 3},
+		{Name: "score", Type: schema.Int, Default: &bad},
+		{Name: "notes"},
+		{Name: "wide", Type: schema.String, MaxLength: 1000, Indexed: true},
+		{Name: "ID", Type: schema.Int},
+	}, "bio")
+
+	rejected := map[string]bool{}
+	for _, v := range violations {
+		rejected[v.Field] = true
+	}
+	for _, field := range []string{"Username", "bio", "age", "score", "notes", "wide", "ID", "primary_key_field"} {
+		if !rejected[field] {
+			t.Errorf("expected a violation for %q", field)
+		}
+	}
+	if rejected["username"] {
+		t.Errorf("unexpected violation for username")
+	}
+}
+
+// Test that values are checked against the logical type of a field
+func TestFieldCheckValue(t *testing.T) {
+	tests := []struct {
+		field schema.Field
+		value string
+		valid bool
+	}{
+		{schema.Field{Type: schema.Email}, "user@example.com", true},
+		{schema.Field{Type: schema.Email}, "User <user@example.com>", false},
+		{schema.Field{Type: schema.Phone}, "+1 (555) 123-4567", true},
+		{schema.Field{Type: schema.Phone}, "call me", false},
+		{schema.Field{Type: schema.String, MaxLength: 3}, "abcd", false},
+		{schema.Field{Type: schema.Int}, "42", true},
+		{schema.Field{Type: schema.Int}, "4.2", false},
+		{schema.Field{Type: schema.Date}, "2024-02-29", true},
+		{schema.Field{Type: schema.Timestamp}, "2024-02-29 10:00", false},
+		{schema.Field{Type: schema.JSON}, `{"a": 1}`, true},
+	}
+	for _, tt := range tests {
+		if description := tt.field.CheckValue(tt.value); (description == "") != tt.valid {
+			t.Errorf("%s %q: expected valid=%v, got %q", tt.field.Type, tt.value, tt.valid, description)
+		}
+	}
+}
+
+// Test that GenerateClientID does not accept both schema formats at once
+func TestGenerateClientIDFieldsAndSchema(t *testing.T) {
+	server := &handlers.AdminServiceServer{}
+
+	req := &pb.GenerateClientRequest{
+		Name:            "Test Client",
+		Email:           "both_schemas@example.com",
+		Schema:          map[string]string{"username": "VARCHAR(50)"},
+		Fields:          []*pb.FieldSpec{{Name: "username", Type: pb.FieldType_FIELD_TYPE_STRING}},
+		PrimaryKeyField: "username",
+	}
+	_, err := server.GenerateClientID(context.Background(), req)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument, got %v", err)
+	}
+}
+
+// Test that user data is checked against the client schema
+func TestValidateRecord(t *testing.T) {
+	fields, violations := schema.ValidateFields([]schema.Field{
+		{Name: "email", Type: schema.Email},
+		{Name: "password", Type: schema.String, Required: true},
+		{Name: "age", Type: schema.Int},
+		{Name: "nickname", Type: schema.String, MaxLength: 5},
+	}, "email")
+	if len(violations) != 0 {
+		t.Fatalf("unexpected violations: %v", violations)
+	}
+
+	valid := map[string]string{"email": "user@example.com", "password": "secret", "age": ""}
+	if violations := schema.ValidateRecord(fields, valid); len(violations) != 0 {
+		t.Errorf("unexpected violations: %v", violations)
+	}
+
+	invalid := map[string]string{"password": "secret", "age": "old", "nickname": "too long", "is_admin": "1"}
+	rejected := map[string]bool{}
+	for _, v := range schema.ValidateRecord(fields, invalid) {
+		rejected[v.Field] = true
+	}
+	for _, field := range []string{"email", "age", "nickname", "is_admin"} {
+		if !rejected[field] {
+			t.Errorf("expected a violation for %q", field)
+		}
+	}
+	if rejected["password"] {
+		t.Errorf("unexpected violation for password")
+	}
+}
+
+// Test that only defined, non-sensitive fields can be profile fields
+func TestValidateProfileFields(t *testing.T) {
+	fields, _ := schema.ValidateFields([]schema.Field{
+		{Name: "email", Type: schema.Email},
+		{Name: "password", Type: schema.String},
+		{Name: "mfa_secret", Type: schema.String, Sensitive: true},
+		{Name: "nickname", Type: schema.String},
+	}, "email")
+
+	if violations := schema.ValidateProfileFields(fields, []string{"email", "nickname"}); len(violations) != 0 {
+		t.Errorf("unexpected violations: %v", violations)
+	}
+	if violations := schema.ValidateProfileFields(fields, []string{"password", "mfa_secret", "age", "email", "email"}); len(violations) != 4 {
+		t.Errorf("expected 4 violations, got %v", violations)
+	}
+}
+
+// Test that a schema diff adds, widens, indexes and deprecates fields
+func TestSchemaDiff(t *testing.T) {
+	current, _ := schema.ValidateFields([]schema.Field{
+		{Name: "email", Type: schema.Email},
+		{Name: "password", Type: schema.String},
+		{Name: "nickname", Type: schema.String, MaxLength: 50},
+		{Name: "age", Type: schema.Int},
+		{Name: "legacy_code", Type: schema.String, MaxLength: 10},
+	}, "email")
+	country := "US"
+	desired, violations := schema.ValidateFields([]schema.Field{
+		{Name: "email", Type: schema.Email},
+		{Name: "password", Type: schema.String},
+		{Name: "nickname", Type: schema.String, MaxLength: 100, Indexed: true},
+		{Name: "age", Type: schema.Int},
+		{Name: "legacy_code", Type: schema.String, MaxLength: 10, Deprecated: true},
+		{Name: "country", Type: schema.String, MaxLength: 2, Required: true, Default: &country},
+	}, "email")
+	if len(violations) != 0 {
+		t.Fatalf("unexpected violations: %v", violations)
+	}
+
+	changes, violations := schema.Diff(current, desired)
+	if len(violations) != 0 {
+		t.Fatalf("unexpected violations: %v", violations)
+	}
+	want := []schema.ChangeKind{schema.WidenType, schema.Deprecate, schema.AddColumn, schema.AddIndex}
+	var got []schema.ChangeKind
+	for _, change := range changes {
+		got = append(got, change.Kind)
+		if change.Destructive {
+			t.Errorf("%s of %s should not be destructive", change.Kind, change.Field)
+		}
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected changes %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected changes %v, got %v", want, got)
+		}
+	}
+	clauses := strings.Join(schema.AlterTable(changes), ", ")
+	for _, clause := range []string{"MODIFY COLUMN `nickname` VARCHAR(100)", "ADD COLUMN `country` VARCHAR(2) NOT NULL DEFAULT 'US'", "ADD KEY `idx_nickname` (`nickname`)"} {
+		if !strings.Contains(clauses, clause) {
+			t.Errorf("expected %q in %q", clause, clauses)
+		}
+	}
+
+	if changes, _ := schema.Diff(desired, desired); len(changes) != 0 {
+		t.Errorf("expected no changes, got %v", changes)
+	}
+}
+
+// Test that dropping columns, narrowing types and requiring values are
+// destructive, and that changes existing users cannot satisfy are rejected
+func TestSchemaDiffDestructive(t *testing.T) {
+	current, _ := schema.ValidateFields([]schema.Field{
+		{Name: "email", Type: schema.Email},
+		{Name: "password", Type: schema.String},
+		{Name: "nickname", Type: schema.String, MaxLength: 100},
+		{Name: "age", Type: schema.Int},
+		{Name: "city", Type: schema.String},
+	}, "email")
+	city := "unknown"
+	desired, _ := schema.ValidateFields([]schema.Field{
+		{Name: "email", Type: schema.Email},
+		{Name: "password", Type: schema.String},
+		{Name: "nickname", Type: schema.String, MaxLength: 20},
+		{Name: "handle", Type: schema.String, Required: true, Unique: true},
+		{Name: "city", Type: schema.String, Required: true, Default: &city},
+	}, "email")
+
+	changes, violations := schema.Diff(current, desired)
+	destructive := map[string]schema.ChangeKind{}
+	for _, change := range changes {
+		if change.Destructive {
+			destructive[change.Field] = change.Kind
+		}
+	}
+	if destructive["nickname"] != schema.ChangeType || destructive["age"] != schema.DropColumn || destructive["city"] != schema.ModifyColumn || len(destructive) != 3 {
+		t.Errorf("expected nickname, age and city to change destructively, got %v", destructive)
+	}
+	if last := changes[len(changes)-1]; last.Kind != schema.DropColumn {
+		t.Errorf("expected columns to be dropped last, got %v", last)
+	}
+	// handle is required without a default, and unique
+	if len(violations) != 2 {
+		t.Errorf("expected 2 violations, got %v", violations)
+	}
+
+	renamed, _ := schema.ValidateFields([]schema.Field{
+		{Name: "Email", Type: schema.Email},
+		{Name: "password", Type: schema.String},
+	}, "Email")
+	if _, violations := schema.Diff(current[:2], renamed); len(violations) != 1 {
+		t.Errorf("expected a violation for the rename, got %v", violations)
+	}
+}
+
+// Test that a schema is checked and rendered without a client
+func TestValidateSchemaDryRun(t *testing.T) {
+	admin := &handlers.AdminServiceServer{}
+
+	resp, err := admin.ValidateSchema(context.Background(), &pb.ValidateSchemaRequest{
+		Fields: []*pb.FieldSpec{
+			{Name: "username", Type: pb.FieldType_FIELD_TYPE_STRING, MaxLength: 50},
+			{Name: "password", Type: pb.FieldType_FIELD_TYPE_STRING},
+		},
+		PrimaryKeyField: "username",
+	})
+	if err != nil {
+		t.Fatalf("ValidateSchema failed: %v", err)
+	}
+	if len(resp.Warnings) != 0 {
+		t.Errorf("unexpected warnings: %v", resp.Warnings)
+	}
+	if len(resp.Fields) != 2 || !resp.Fields[0].Unique || !resp.Fields[1].Sensitive {
+		t.Errorf("expected the normalized fields, got %v", resp.Fields)
+	}
+	if len(resp.Statements) != 5 || !strings.HasPrefix(resp.Statements[1], "CREATE TABLE IF NOT EXISTS client_<client_id>.users (") || !strings.Contains(resp.Statements[1], "`username` VARCHAR(50) NOT NULL") || !strings.Contains(resp.Statements[2], "client_<client_id>.refresh_tokens") {
+		t.Errorf("unexpected statements: %v", resp.Statements)
+	}
+
+	_, err = admin.ValidateSchema(context.Background(), &pb.ValidateSchemaRequest{
+		Schema:          map[string]string{"username": "VARCHAR(50)"},
+		PrimaryKeyField: "email",
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument, got %v", err)
+	}
+}
+
+// Test the warnings of schemas that are valid but likely mistaken
+func TestSchemaWarnings(t *testing.T) {
+	fields, violations := schema.ValidateFields([]schema.Field{
+		{Name: "joined", Type: schema.Date},
+		{Name: "bio", Type: schema.String, MaxLength: 8000},
+		{Name: "notes", Type: schema.String, MaxLength: 5000},
+	}, "joined")
+	if len(violations) != 0 {
+		t.Fatalf("unexpected violations: %v", violations)
+	}
+
+	found := map[string]int{}
+	for _, w := range schema.Warnings(fields, "joined") {
+		found[w.Code]++
+	}
+	want := map[string]int{
+		schema.WarningNoUniqueIdentifier: 1,
+		schema.WarningOversizedColumn:    2,
+		schema.WarningMissingPassword:    1,
+	}
+	for code, n := range want {
+		if found[code] != n {
+			t.Errorf("expected %d %s warnings, got %v", n, code, found)
+		}
+	}
+
+	// A user table MySQL cannot create is invalid, not just likely mistaken
+	_, violations = schema.ValidateFields([]schema.Field{
+		{Name: "joined", Type: schema.Date},
+		{Name: "bio", Type: schema.String, MaxLength: 8000},
+		{Name: "notes", Type: schema.String, MaxLength: 10000},
+	}, "joined")
+	if len(violations) != 1 || violations[0].Field != "fields" {
+		t.Errorf("expected a violation for the row size, got %v", violations)
+	}
+}
+
+// Test that column definitions stored by clients registered before schemas
+// were validated are kept with their modifiers
+func TestFromStored(t *testing.T) {
+	fields := schema.FromStored(map[string]string{
+		"username": "VARCHAR(50) NOT NULL",
+		"password": "VARCHAR(50) NOT NULL",
+		"email":    "varchar(100) unique",
+		"age":      "INT(11) DEFAULT 18",
+		"country":  "CHAR(2) NOT NULL DEFAULT 'US'",
+		"bad name": "TEXT",
+	}, "username")
+
+	byName := map[string]schema.Field{}
+	for _, f := range fields {
+		byName[f.Name] = f
+	}
+	if len(fields) != 5 || fields[0].Name != "username" {
+		t.Fatalf("expected 5 fields with the primary key first, got %v", fields)
+	}
+	if f := byName["username"]; f.ColumnType() != "VARCHAR(50)" || !f.Required || f.Unique {
+		t.Errorf("unexpected primary key: %+v", f)
+	}
+	if f := byName["password"]; !f.Sensitive || !f.Required {
+		t.Errorf("unexpected password: %+v", f)
+	}
+	if f := byName["email"]; !f.Unique || f.Required {
+		t.Errorf("unexpected email: %+v", f)
+	}
+	if f := byName["age"]; f.Type != schema.Int || f.ColumnType() != "INT(11)" || f.Default == nil || *f.Default != "18" {
+		t.Errorf("unexpected age: %+v", f)
+	}
+	if f := byName["country"]; f.Default == nil || *f.Default != "US" || !f.Required {
+		t.Errorf("unexpected country: %+v", f)
+	}
+	if violations := schema.ValidateRecord(fields, map[string]string{"username": "user", "password": "secret"}); len(violations) != 0 {
+		t.Errorf("unexpected violations: %v", violations)
+	}
+}
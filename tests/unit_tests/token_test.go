@@ -0,0 +1,248 @@
+package handlers_test
+
+import (
+	"auth-service/token"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Test that access tokens carry the expected claims and verify with the
+// public key of the keystore
+func TestIssueAccessToken(t *testing.T) {
+	signed, expiresIn, err := token.IssueAccessToken(context.Background(), "testClient", "newUser", "session")
+	if err != nil {
+		t.Fatalf("IssueAccessToken failed: %v", err)
+	}
+	if expiresIn <= 0 {
+		t.Errorf("expected positive lifetime, got %v", expiresIn)
+	}
+
+	claims := &token.Claims{}
+	parsed, err := jwt.ParseWithClaims(signed, claims, func(t *jwt.Token) (interface{}, error) {
+		return publicKey(t.Header["kid"].(string))
+	})
+	if err != nil || !parsed.Valid {
+		t.Fatalf("failed to verify access token: %v", err)
+	}
+	if claims.Subject != "newUser" || claims.ClientID != "testClient" || claims.SessionID != "session" || claims.Issuer != "auth-service-test" {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+	if claims.IssuedAt == nil || claims.ExpiresAt == nil || claims.ID == "" {
+		t.Errorf("missing iat, exp or jti: %+v", claims)
+	}
+}
+
+// Test that every supported algorithm can be generated and reloaded
+func TestLoadKeystore(t *testing.T) {
+	for _, alg := range []string{token.RS256, token.ES256, token.EdDSA} {
+		dir := t.TempDir()
+		ks, err := token.LoadKeystore(dir, "", alg)
+		if err != nil {
+			t.Fatalf("%s: LoadKeystore failed: %v", alg, err)
+		}
+		key := ks.SigningKey()
+		if key.Algorithm != alg {
+			t.Errorf("expected %s key, got %s", alg, key.Algorithm)
+		}
+
+		reloaded, err := token.LoadKeystore(dir, key.ID, "")
+		if err != nil {
+			t.Fatalf("%s: reloading keystore failed: %v", alg, err)
+		}
+		if reloaded.SigningKey().ID != key.ID || reloaded.SigningKey().Algorithm != alg {
+			t.Errorf("%s: reloaded key does not match: %+v", alg, reloaded.SigningKey())
+		}
+	}
+
+	if _, err := token.LoadKeystore(t.TempDir(), "missing", token.ES256); err == nil {
+		t.Errorf("expected an error for an unknown active key")
+	}
+}
+
+func publicKey(kid string) (crypto.PublicKey, error) {
+	ks, err := token.LoadKeystore(keystoreDir, kid, "")
+	if err != nil {
+		return nil, err
+	}
+	return ks.SigningKey().Public(), nil
+}
+
+// Test that access tokens validate only for their own client and only when
+// untampered
+func TestValidateAccessToken(t *testing.T) {
+	signed, _, err := token.IssueAccessToken(context.Background(), "testClient", "newUser", "")
+	if err != nil {
+		t.Fatalf("IssueAccessToken failed: %v", err)
+	}
+
+	info, err := token.Validate(context.Background(), "testClient", signed)
+	if err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if info.TokenType != token.AccessTokenType || info.Subject != "newUser" || info.ID == "" {
+		t.Errorf("unexpected token info: %+v", info)
+	}
+
+	if _, err := token.Validate(context.Background(), "otherClient", signed); !errors.Is(err, token.ErrInvalidToken) {
+		t.Errorf("expected ErrInvalidToken for another client, got %v", err)
+	}
+
+	tampered := signed[:len(signed)-4] + "AAAA"
+	if _, err := token.Validate(context.Background(), "testClient", tampered); !errors.Is(err, token.ErrInvalidToken) {
+		t.Errorf("expected ErrInvalidToken for a tampered token, got %v", err)
+	}
+}
+
+// Test that a rotated key is published before it signs and that the old key
+// stays published while its tokens may still be valid
+func TestKeystoreRotation(t *testing.T) {
+	ks, err := token.LoadKeystore(t.TempDir(), "", token.ES256)
+	if err != nil {
+		t.Fatalf("LoadKeystore failed: %v", err)
+	}
+	old := ks.SigningKey()
+
+	pending, err := ks.Rotate(token.EdDSA, time.Hour)
+	if err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	if ks.SigningKey().ID != old.ID {
+		t.Errorf("expected the old key to sign during the grace period")
+	}
+	statuses := keyStatuses(ks.Published(time.Hour))
+	if statuses[old.ID] != token.KeyActive || statuses[pending.ID] != token.KeyPending {
+		t.Errorf("unexpected key statuses: %v", statuses)
+	}
+
+	next, err := ks.Rotate(token.ES256, 0)
+	if err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	if ks.SigningKey().ID != next.ID {
+		t.Errorf("expected the key without grace period to sign")
+	}
+	statuses = keyStatuses(ks.Published(time.Hour))
+	if statuses[old.ID] != token.KeyRetired || statuses[next.ID] != token.KeyActive {
+		t.Errorf("unexpected key statuses: %v", statuses)
+	}
+
+	// Without a retention period the retired key is deleted
+	if err := ks.Prune(0); err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if _, ok := ks.Key(old.ID); ok {
+		t.Errorf("expected the retired key to be pruned")
+	}
+	if err := ks.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if ks.SigningKey().ID != next.ID {
+		t.Errorf("expected the signing key to survive a reload")
+	}
+}
+
+// Test the JWK encoding of every supported key type
+func TestKeyJWK(t *testing.T) {
+	want := map[string]string{token.RS256: "RSA", token.ES256: "EC", token.EdDSA: "OKP"}
+	for alg, kty := range want {
+		key, err := token.GenerateKey(alg)
+		if err != nil {
+			t.Fatalf("GenerateKey failed: %v", err)
+		}
+		jwk := key.JWK()
+		if jwk.Kty != kty || jwk.Kid != key.ID || jwk.Alg != alg || jwk.Use != "sig" {
+			t.Errorf("unexpected JWK for %s: %+v", alg, jwk)
+		}
+		if (kty == "RSA" && (jwk.N == "" || jwk.E == "")) || (kty != "RSA" && jwk.X == "") {
+			t.Errorf("missing key material for %s: %+v", alg, jwk)
+		}
+	}
+}
+
+func keyStatuses(published []token.PublishedKey) map[string]string {
+	statuses := map[string]string{}
+	for _, key := range published {
+		statuses[key.ID] = key.Status
+	}
+	return statuses
+}
+
+// Test that per-client signing keys are stored encrypted
+func TestNewTenantKey(t *testing.T) {
+	stored, err := token.NewTenantKey("672e6755878f1dd94d4aa61d", token.ES256)
+	if err != nil {
+		t.Fatalf("NewTenantKey failed: %v", err)
+	}
+	if stored.ID == "" || stored.Algorithm != token.ES256 || len(stored.EncryptedPrivateKey) == 0 {
+		t.Errorf("unexpected tenant key: %+v", stored)
+	}
+	if _, err := x509.ParsePKCS8PrivateKey(stored.EncryptedPrivateKey); err == nil {
+		t.Errorf("expected the private key to be encrypted")
+	}
+	if _, err := x509.ParsePKIXPublicKey(stored.PublicKey); err != nil {
+		t.Errorf("failed to parse public key: %v", err)
+	}
+}
+
+// Test that an emptied keystore directory keeps the loaded keys
+func TestKeystoreEmptiedDirectory(t *testing.T) {
+	if _, ok := (&token.Keystore{}).Newest(); ok {
+		t.Errorf("expected an empty keystore to have no newest key")
+	}
+
+	dir := t.TempDir()
+	ks, err := token.LoadKeystore(dir, "", token.ES256)
+	if err != nil {
+		t.Fatalf("LoadKeystore failed: %v", err)
+	}
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.pem"))
+	for _, path := range paths {
+		os.Remove(path)
+	}
+	if err := ks.Reload(); err == nil {
+		t.Errorf("expected reloading an emptied keystore to fail")
+	}
+	if newest, ok := ks.Newest(); !ok || ks.SigningKey() == nil || newest.ID != ks.SigningKey().ID {
+		t.Errorf("expected the loaded key to be kept")
+	}
+}
+
+// Test that instances sharing a keystore keep each other's key metadata
+func TestKeystoreSharedDirectory(t *testing.T) {
+	dir := t.TempDir()
+	first, err := token.LoadKeystore(dir, "", token.ES256)
+	if err != nil {
+		t.Fatalf("LoadKeystore failed: %v", err)
+	}
+	second, err := token.LoadKeystore(dir, "", token.ES256)
+	if err != nil {
+		t.Fatalf("LoadKeystore failed: %v", err)
+	}
+
+	rotated, err := first.Rotate(token.ES256, time.Hour)
+	if err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	// The second instance has not seen the new key when it prunes
+	if err := second.Prune(time.Hour); err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if err := second.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	key, ok := second.Key(rotated.ID)
+	if !ok || !key.ActivatesAt.Equal(rotated.ActivatesAt) {
+		t.Fatalf("expected the rotated key with its activation time, got %v", key)
+	}
+	if second.SigningKey().ID == rotated.ID {
+		t.Errorf("expected the rotated key to wait for its grace period")
+	}
+}
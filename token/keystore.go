@@ -0,0 +1,458 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Supported signing algorithms.
+const (
+	RS256 = "RS256"
+	ES256 = "ES256"
+	EdDSA = "EdDSA"
+)
+
+// Key is a signing key pair identified by its kid.
+type Key struct {
+	ID          string
+	Algorithm   string
+	Private     crypto.Signer
+	CreatedAt   time.Time
+	ActivatesAt time.Time // when the key starts signing tokens
+}
+
+// Public returns the public half of the key.
+func (k *Key) Public() crypto.PublicKey {
+	return k.Private.Public()
+}
+
+func (k *Key) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+// Keystore holds the signing keys read from a local directory. Every key is
+// a PKCS#8 PEM file named <kid>.pem, next to <kid>.json recording when it
+// was created and when it starts signing tokens. Instances sharing the
+// directory only write the files of keys they create, so one instance
+// rotating or pruning keys never overwrites what another wrote.
+type Keystore struct {
+	dir    string
+	pinned string // signing key chosen by configuration, if any
+
+	mu   sync.RWMutex
+	keys map[string]*Key
+}
+
+// keyMetadata is the content of <kid>.json.
+type keyMetadata struct {
+	ID          string    `json:"kid"`
+	CreatedAt   time.Time `json:"created_at"`
+	ActivatesAt time.Time `json:"activates_at"`
+}
+
+// legacyMetadataFile lists the metadata of all keys in keystores written
+// before keys had metadata files of their own. It is only read.
+const legacyMetadataFile = "keys.json"
+
+// LoadKeystore reads all keys in dir. activeKID pins the signing key; when it
+// is empty the most recently activated key signs. An empty or missing
+// directory is initialized with a new key using algorithm.
+func LoadKeystore(dir, activeKID, algorithm string) (*Keystore, error) {
+	ks := &Keystore{dir: dir, pinned: activeKID}
+	if err := ks.Reload(); err != nil {
+		return nil, err
+	}
+
+	if len(ks.keys) == 0 {
+		key, err := GenerateKey(algorithm)
+		if err != nil {
+			return nil, err
+		}
+		if err := ks.add(key); err != nil {
+			return nil, err
+		}
+	}
+
+	if activeKID != "" {
+		if _, ok := ks.keys[activeKID]; !ok {
+			return nil, fmt.Errorf("active signing key %q not found in %s", activeKID, dir)
+		}
+	}
+	return ks, nil
+}
+
+// Reload reads the keystore directory again, picking up keys that were
+// rotated by another instance sharing it.
+func (ks *Keystore) Reload() error {
+	paths, err := filepath.Glob(filepath.Join(ks.dir, "*.pem"))
+	if err != nil {
+		return fmt.Errorf("failed to list keystore: %w", err)
+	}
+	legacy, err := ks.readLegacyMetadata()
+	if err != nil {
+		return err
+	}
+
+	keys := map[string]*Key{}
+	for _, path := range paths {
+		key, err := readKey(path)
+		if err != nil {
+			return err
+		}
+		meta, ok, err := ks.readMetadata(key.ID)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			meta, ok = legacy[key.ID]
+		}
+		if ok {
+			key.CreatedAt, key.ActivatesAt = meta.CreatedAt, meta.ActivatesAt
+		}
+		keys[key.ID] = key
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	// Tokens still need signing, so an emptied directory keeps the keys
+	// loaded before
+	if len(keys) == 0 && len(ks.keys) > 0 {
+		return fmt.Errorf("keystore %s has no keys, keeping the %d loaded", ks.dir, len(ks.keys))
+	}
+	ks.keys = keys
+	return nil
+}
+
+// SigningKey returns the key new tokens are signed with: the pinned key, or
+// else the key activated most recently.
+func (ks *Keystore) SigningKey() *Key {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.signingKey(time.Now())
+}
+
+func (ks *Keystore) signingKey(now time.Time) *Key {
+	if key, ok := ks.keys[ks.pinned]; ok {
+		return key
+	}
+	var signing *Key
+	for _, key := range ks.sorted() {
+		if signing == nil || !key.ActivatesAt.After(now) {
+			signing = key
+		}
+	}
+	return signing
+}
+
+// Key returns the key with the given kid.
+func (ks *Keystore) Key(kid string) (*Key, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	key, ok := ks.keys[kid]
+	return key, ok
+}
+
+// Newest returns the most recently introduced key, which may still be
+// waiting to sign. It reports false if the keystore is empty.
+func (ks *Keystore) Newest() (*Key, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	sorted := ks.sorted()
+	if len(sorted) == 0 {
+		return nil, false
+	}
+	return sorted[len(sorted)-1], true
+}
+
+// Key states reported by Published.
+const (
+	KeyPending = "pending"
+	KeyActive  = "active"
+	KeyRetired = "retired"
+)
+
+// PublishedKey is a key that verifiers should know about.
+type PublishedKey struct {
+	*Key
+	Status    string
+	RetiredAt time.Time // zero unless retired
+}
+
+// Published returns the keys verifiers need: keys waiting to sign, the
+// signing key, and retired keys whose tokens may not have expired yet given
+// the longest token lifetime retention.
+func (ks *Keystore) Published(retention time.Duration) []PublishedKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.published(time.Now(), retention)
+}
+
+func (ks *Keystore) published(now time.Time, retention time.Duration) []PublishedKey {
+	signing := ks.signingKey(now)
+	sorted := ks.sorted()
+
+	var published []PublishedKey
+	for i, key := range sorted {
+		switch {
+		case key == signing:
+			published = append(published, PublishedKey{Key: key, Status: KeyActive})
+		case key.ActivatesAt.After(now):
+			published = append(published, PublishedKey{Key: key, Status: KeyPending})
+		default:
+			// A key retires when its successor starts signing
+			retiredAt := now
+			if i+1 < len(sorted) && !sorted[i+1].ActivatesAt.After(now) {
+				retiredAt = sorted[i+1].ActivatesAt
+			}
+			if now.Before(retiredAt.Add(retention)) {
+				published = append(published, PublishedKey{Key: key, Status: KeyRetired, RetiredAt: retiredAt})
+			}
+		}
+	}
+	return published
+}
+
+// Rotate introduces a new signing key. It is published right away and starts
+// signing tokens after grace, giving verifiers time to fetch it.
+func (ks *Keystore) Rotate(algorithm string, grace time.Duration) (*Key, error) {
+	if ks.pinned != "" {
+		return nil, fmt.Errorf("signing key is pinned to %q", ks.pinned)
+	}
+	key, err := GenerateKey(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	key.ActivatesAt = key.CreatedAt.Add(grace)
+	if err := ks.add(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Prune deletes keys that are no longer published because every token they
+// signed has expired.
+func (ks *Keystore) Prune(retention time.Duration) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	keep := map[string]bool{}
+	for _, key := range ks.published(time.Now(), retention) {
+		keep[key.ID] = true
+	}
+	for kid := range ks.keys {
+		if keep[kid] {
+			continue
+		}
+		// The key goes first, so that no instance loads it without its
+		// metadata
+		for _, name := range []string{kid + ".pem", kid + ".json"} {
+			if err := os.Remove(filepath.Join(ks.dir, name)); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to delete signing key: %w", err)
+			}
+		}
+		delete(ks.keys, kid)
+	}
+	return nil
+}
+
+// sorted returns the keys ordered by activation time.
+func (ks *Keystore) sorted() []*Key {
+	sorted := make([]*Key, 0, len(ks.keys))
+	for _, key := range ks.keys {
+		sorted = append(sorted, key)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].ActivatesAt.Equal(sorted[j].ActivatesAt) {
+			return sorted[i].ID < sorted[j].ID
+		}
+		return sorted[i].ActivatesAt.Before(sorted[j].ActivatesAt)
+	})
+	return sorted
+}
+
+// add saves a new key. Its metadata is written before the key, so that other
+// instances never load the key without knowing when it starts signing.
+func (ks *Keystore) add(key *Key) error {
+	if err := ks.writeMetadata(key); err != nil {
+		return err
+	}
+	if err := ks.write(key); err != nil {
+		return err
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if ks.keys == nil {
+		ks.keys = map[string]*Key{}
+	}
+	ks.keys[key.ID] = key
+	return nil
+}
+
+// readMetadata reads <kid>.json, reporting false if it does not exist.
+func (ks *Keystore) readMetadata(kid string) (keyMetadata, bool, error) {
+	var meta keyMetadata
+	data, err := os.ReadFile(filepath.Join(ks.dir, kid+".json"))
+	if os.IsNotExist(err) {
+		return meta, false, nil
+	}
+	if err != nil {
+		return meta, false, fmt.Errorf("failed to read metadata of signing key %s: %w", kid, err)
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return meta, false, fmt.Errorf("failed to parse metadata of signing key %s: %w", kid, err)
+	}
+	return meta, true, nil
+}
+
+func (ks *Keystore) readLegacyMetadata() (map[string]keyMetadata, error) {
+	data, err := os.ReadFile(filepath.Join(ks.dir, legacyMetadataFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keystore metadata: %w", err)
+	}
+	var entries []keyMetadata
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse keystore metadata: %w", err)
+	}
+	metadata := make(map[string]keyMetadata, len(entries))
+	for _, entry := range entries {
+		metadata[entry.ID] = entry
+	}
+	return metadata, nil
+}
+
+// writeMetadata saves <kid>.json.
+func (ks *Keystore) writeMetadata(key *Key) error {
+	data, err := json.MarshalIndent(keyMetadata{ID: key.ID, CreatedAt: key.CreatedAt, ActivatesAt: key.ActivatesAt}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode keystore metadata: %w", err)
+	}
+	if err := os.MkdirAll(ks.dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create keystore: %w", err)
+	}
+	if err := writeFile(filepath.Join(ks.dir, key.ID+".json"), data); err != nil {
+		return fmt.Errorf("failed to write keystore metadata: %w", err)
+	}
+	return nil
+}
+
+var ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+
+// GenerateKey creates a new key pair for the algorithm.
+func GenerateKey(algorithm string) (*Key, error) {
+	var signer crypto.Signer
+	var err error
+	switch algorithm {
+	case RS256:
+		signer, err = rsa.GenerateKey(rand.Reader, 2048)
+	case ES256:
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case EdDSA:
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnsupportedAlgorithm, algorithm)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	now := time.Now().UTC()
+	return &Key{ID: newKeyID(), Algorithm: algorithm, Private: signer, CreatedAt: now, ActivatesAt: now}, nil
+}
+
+// newKeyID returns a kid that sorts by creation time.
+func newKeyID() string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(suffix)
+}
+
+func (ks *Keystore) write(key *Key) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key.Private)
+	if err != nil {
+		return fmt.Errorf("failed to encode signing key: %w", err)
+	}
+	if err := os.MkdirAll(ks.dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create keystore: %w", err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := writeFile(filepath.Join(ks.dir, key.ID+".pem"), data); err != nil {
+		return fmt.Errorf("failed to write signing key: %w", err)
+	}
+	return nil
+}
+
+// writeFile writes a file of the keystore through a temporary file, so that
+// other instances never read it half written.
+func writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func readKey(path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key %s: %w", path, err)
+	}
+
+	// Keys without metadata, e.g. copied in by an operator, sign from the
+	// moment they were written
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+	modified := info.ModTime().UTC()
+	key := &Key{ID: strings.TrimSuffix(filepath.Base(path), ".pem"), CreatedAt: modified, ActivatesAt: modified}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Algorithm, key.Private = RS256, k
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("signing key %s: only P-256 EC keys are supported", path)
+		}
+		key.Algorithm, key.Private = ES256, k
+	case ed25519.PrivateKey:
+		key.Algorithm, key.Private = EdDSA, k
+	default:
+		return nil, fmt.Errorf("signing key %s: unsupported key type %T", path, parsed)
+	}
+	return key, nil
+}
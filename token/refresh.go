@@ -0,0 +1,120 @@
+package token
+
+import (
+	"auth-service/db"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	// ErrRefreshTokenReused is returned when a refresh token that was
+	// already rotated is presented again. Its whole family is revoked.
+	ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+)
+
+// IssueRefreshToken creates an opaque refresh token for the subject. An empty
+// familyID starts a new family; the family ID is returned either way.
+func IssueRefreshToken(ctx context.Context, clientID, subject, familyID string) (string, string, error) {
+	if err := db.EnsureTokenTables(clientID); err != nil {
+		return "", "", err
+	}
+	return insertRefreshToken(ctx, db.MySQLClient, clientID, subject, familyID)
+}
+
+// RotateRefreshToken exchanges a refresh token for a new one in the same
+// family and returns the subject, the family ID and the new token. Presenting
+// a token that was already rotated revokes the family.
+func RotateRefreshToken(ctx context.Context, clientID, refreshToken string) (subject, familyID, newToken string, err error) {
+	if err := db.EnsureTokenTables(clientID); err != nil {
+		return "", "", "", err
+	}
+	dbName := fmt.Sprintf("client_%s", clientID)
+
+	tx, err := db.MySQLClient.BeginTx(ctx, nil)
+	if err != nil {
+		return "", "", "", fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var active, rotated, revoked bool
+	query := fmt.Sprintf(`SELECT subject, family_id, expires_at > UTC_TIMESTAMP(), rotated_at IS NOT NULL, revoked_at IS NOT NULL
+		FROM %s.refresh_tokens WHERE token_hash = ? FOR UPDATE`, dbName)
+	err = tx.QueryRowContext(ctx, query, hashToken(refreshToken)).Scan(&subject, &familyID, &active, &rotated, &revoked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", "", "", ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return "", "", "", fmt.Errorf("failed to look up refresh token: %w", err)
+	}
+
+	switch {
+	case revoked:
+		return "", "", "", ErrInvalidRefreshToken
+	case rotated:
+		// The token was already exchanged, so either the client or an
+		// attacker holds a stolen copy. End the whole session.
+		_, err = tx.ExecContext(ctx, fmt.Sprintf("UPDATE %s.refresh_tokens SET revoked_at = ? WHERE family_id = ? AND revoked_at IS NULL", dbName),
+			time.Now().UTC(), familyID)
+		if err != nil {
+			return "", "", "", fmt.Errorf("failed to revoke token family: %w", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return "", "", "", fmt.Errorf("failed to revoke token family: %w", err)
+		}
+		return "", "", "", ErrRefreshTokenReused
+	case !active:
+		return "", "", "", ErrInvalidRefreshToken
+	}
+
+	_, err = tx.ExecContext(ctx, fmt.Sprintf("UPDATE %s.refresh_tokens SET rotated_at = ? WHERE token_hash = ?", dbName),
+		time.Now().UTC(), hashToken(refreshToken))
+	if err != nil {
+		return "", "", "", fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+	newToken, _, err = insertRefreshToken(ctx, tx, clientID, subject, familyID)
+	if err != nil {
+		return "", "", "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", "", "", fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+	return subject, familyID, newToken, nil
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func insertRefreshToken(ctx context.Context, e execer, clientID, subject, familyID string) (string, string, error) {
+	if familyID == "" {
+		familyID = newTokenID()
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	refreshToken := base64.RawURLEncoding.EncodeToString(b)
+
+	now := time.Now().UTC()
+	query := fmt.Sprintf("INSERT INTO client_%s.refresh_tokens (token_hash, family_id, subject, issued_at, expires_at) VALUES (?, ?, ?, ?, ?)", clientID)
+	_, err := e.ExecContext(ctx, query, hashToken(refreshToken), familyID, subject, now, now.Add(refreshTTL))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to store refresh token: %w", err)
+	}
+	return refreshToken, familyID, nil
+}
+
+// hashToken returns the hex SHA-256 digest under which a refresh token is
+// stored. Refresh tokens are random, so a fast hash is sufficient.
+func hashToken(t string) string {
+	sum := sha256.Sum256([]byte(t))
+	return hex.EncodeToString(sum[:])
+}
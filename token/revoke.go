@@ -0,0 +1,88 @@
+package token
+
+import (
+	"auth-service/db"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// RevokeRefreshToken revokes the login session the refresh token belongs to.
+func RevokeRefreshToken(ctx context.Context, clientID, refreshToken string) error {
+	if err := db.EnsureTokenTables(clientID); err != nil {
+		return err
+	}
+	var familyID string
+	query := fmt.Sprintf("SELECT family_id FROM client_%s.refresh_tokens WHERE token_hash = ?", clientID)
+	err := db.MySQLClient.QueryRowContext(ctx, query, hashToken(refreshToken)).Scan(&familyID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return fmt.Errorf("failed to look up refresh token: %w", err)
+	}
+	return RevokeSession(ctx, clientID, familyID)
+}
+
+// RevokeSession revokes every refresh token of a login session. Access tokens
+// issued with the session fail validation from then on.
+func RevokeSession(ctx context.Context, clientID, sessionID string) error {
+	if err := db.EnsureTokenTables(clientID); err != nil {
+		return err
+	}
+	query := fmt.Sprintf("UPDATE client_%s.refresh_tokens SET revoked_at = ? WHERE family_id = ? AND revoked_at IS NULL", clientID)
+	if _, err := db.MySQLClient.ExecContext(ctx, query, time.Now().UTC(), sessionID); err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	return nil
+}
+
+// RevokeAccessToken puts an access token on the client's revocation list
+// until it expires.
+func RevokeAccessToken(ctx context.Context, clientID string, info *Info) error {
+	if err := db.EnsureTokenTables(clientID); err != nil {
+		return err
+	}
+	// Expired entries are no longer needed since the tokens fail validation anyway
+	_, err := db.MySQLClient.ExecContext(ctx, fmt.Sprintf("DELETE FROM client_%s.revoked_tokens WHERE expires_at < UTC_TIMESTAMP()", clientID))
+	if err != nil {
+		return fmt.Errorf("failed to prune revoked tokens: %w", err)
+	}
+	query := fmt.Sprintf("INSERT IGNORE INTO client_%s.revoked_tokens (jti, expires_at) VALUES (?, ?)", clientID)
+	if _, err := db.MySQLClient.ExecContext(ctx, query, info.ID, info.ExpiresAt.UTC()); err != nil {
+		return fmt.Errorf("failed to revoke access token: %w", err)
+	}
+	return nil
+}
+
+// RevokeAllSessions revokes every session of a subject within a client,
+// including access tokens issued up to now, and returns how many sessions
+// were still active.
+func RevokeAllSessions(ctx context.Context, clientID, subject string) (int64, error) {
+	if err := db.EnsureTokenTables(clientID); err != nil {
+		return 0, err
+	}
+	// Token iat claims have second precision, so the cutoff only covers
+	// tokens issued in earlier seconds. Tokens issued earlier in this second
+	// belong to the sessions revoked below, while tokens of logins right
+	// after the revocation stay valid.
+	now := time.Now().UTC().Truncate(time.Second)
+
+	query := fmt.Sprintf("SELECT COUNT(DISTINCT family_id) FROM client_%s.refresh_tokens WHERE subject = ? AND revoked_at IS NULL AND expires_at > ?", clientID)
+	var sessions int64
+	if err := db.MySQLClient.QueryRowContext(ctx, query, subject, now).Scan(&sessions); err != nil {
+		return 0, fmt.Errorf("failed to count sessions: %w", err)
+	}
+
+	query = fmt.Sprintf("UPDATE client_%s.refresh_tokens SET revoked_at = ? WHERE subject = ? AND revoked_at IS NULL", clientID)
+	if _, err := db.MySQLClient.ExecContext(ctx, query, now, subject); err != nil {
+		return 0, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	query = fmt.Sprintf("INSERT INTO client_%s.session_revocations (subject, revoked_at) VALUES (?, ?) ON DUPLICATE KEY UPDATE revoked_at = VALUES(revoked_at)", clientID)
+	if _, err := db.MySQLClient.ExecContext(ctx, query, subject, now); err != nil {
+		return 0, fmt.Errorf("failed to revoke access tokens: %w", err)
+	}
+	return sessions, nil
+}
@@ -0,0 +1,179 @@
+package token
+
+import (
+	"auth-service/db"
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TenantKey is a client's own signing key as stored on its client document.
+// The private key is encrypted with the key encryption key and bound to the
+// client ID, so a copied document cannot be used for another client.
+type TenantKey struct {
+	ID                  string    `bson:"kid"`
+	Algorithm           string    `bson:"alg"`
+	EncryptedPrivateKey []byte    `bson:"encrypted_private_key"`
+	PublicKey           []byte    `bson:"public_key"` // PKIX DER
+	CreatedAt           time.Time `bson:"created_at"`
+}
+
+var ErrNoKeyEncryptionKey = errors.New("per-client signing keys require a key encryption key")
+
+// tenantKeys caches the decrypted signing key of each client, or nil for
+// clients that share the service keys.
+var tenantKeys sync.Map
+
+// NewTenantKey generates a signing key for the client and encrypts it for
+// storage. An empty algorithm uses the algorithm of the service key.
+func NewTenantKey(clientID, algorithm string) (*TenantKey, error) {
+	if len(keyEncryptionKey) == 0 {
+		return nil, ErrNoKeyEncryptionKey
+	}
+	if algorithm == "" && keys != nil {
+		algorithm = keys.SigningKey().Algorithm
+	}
+	key, err := GenerateKey(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	private, err := x509.MarshalPKCS8PrivateKey(key.Private)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode signing key: %w", err)
+	}
+	public, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode public key: %w", err)
+	}
+	sealed, err := seal(private, clientID)
+	if err != nil {
+		return nil, err
+	}
+	return &TenantKey{
+		ID:                  key.ID,
+		Algorithm:           key.Algorithm,
+		EncryptedPrivateKey: sealed,
+		PublicKey:           public,
+		CreatedAt:           key.CreatedAt,
+	}, nil
+}
+
+// tenantKey returns the client's own signing key, or nil when the client
+// uses the service keys.
+func tenantKey(ctx context.Context, clientID string) (*Key, error) {
+	if cached, ok := tenantKeys.Load(clientID); ok {
+		return cached.(*Key), nil
+	}
+	// Client IDs are ObjectIDs; anything else cannot have a key of its own
+	objectID, err := primitive.ObjectIDFromHex(clientID)
+	if err != nil {
+		return nil, nil
+	}
+
+	var client struct {
+		SigningKey *TenantKey `bson:"signing_key"`
+	}
+	opts := options.FindOne().SetProjection(bson.M{"signing_key": 1})
+	err = db.GetClientsCollection().FindOne(ctx, bson.M{"_id": objectID}, opts).Decode(&client)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// Unknown clients are not cached since they may be created later
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up client signing key: %w", err)
+	}
+
+	var key *Key
+	if client.SigningKey != nil {
+		if key, err = openTenantKey(clientID, client.SigningKey); err != nil {
+			return nil, err
+		}
+	}
+	tenantKeys.Store(clientID, key)
+	return key, nil
+}
+
+func openTenantKey(clientID string, stored *TenantKey) (*Key, error) {
+	if len(keyEncryptionKey) == 0 {
+		return nil, ErrNoKeyEncryptionKey
+	}
+	der, err := open(stored.EncryptedPrivateKey, clientID)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse client signing key: %w", err)
+	}
+	signer, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported client signing key type %T", parsed)
+	}
+	return &Key{
+		ID:          stored.ID,
+		Algorithm:   stored.Algorithm,
+		Private:     signer,
+		CreatedAt:   stored.CreatedAt,
+		ActivatesAt: stored.CreatedAt,
+	}, nil
+}
+
+// tenantIssuer is the issuer of tokens signed with a client's own key.
+func tenantIssuer(clientID string) string {
+	return issuer + "/clients/" + clientID
+}
+
+// seal encrypts data with AES-256-GCM under the key encryption key, using
+// the client ID as additional data.
+func seal(data []byte, clientID string) ([]byte, error) {
+	aead, err := newAEAD()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, data, []byte(clientID)), nil
+}
+
+func open(sealed []byte, clientID string) ([]byte, error) {
+	aead, err := newAEAD()
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("client signing key is corrupt")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	data, err := aead.Open(nil, nonce, ciphertext, []byte(clientID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt client signing key: %w", err)
+	}
+	return data, nil
+}
+
+func newAEAD() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(keyEncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key encryption key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// ForgetTenantKey drops the cached signing key of a deleted client.
+func ForgetTenantKey(clientID string) {
+	tenantKeys.Delete(clientID)
+}
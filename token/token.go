@@ -0,0 +1,167 @@
+package token
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Type is the token type returned alongside access tokens.
+const Type = "Bearer"
+
+// Config describes where signing keys live and how tokens are issued.
+type Config struct {
+	KeystoreDir string
+	ActiveKeyID string
+	Algorithm   string // used when the keystore has to be initialized
+	Issuer      string
+	AccessTTL   time.Duration
+	RefreshTTL  time.Duration
+
+	// KeyEncryptionKey is the AES-256 key protecting per-client signing
+	// keys. They are unavailable when it is empty.
+	KeyEncryptionKey []byte
+}
+
+// Claims are the claims carried by access tokens.
+type Claims struct {
+	ClientID  string `json:"client_id"`
+	SessionID string `json:"sid,omitempty"` // refresh token family
+	jwt.RegisteredClaims
+}
+
+var (
+	keys       *Keystore
+	issuer     string
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+
+	keyEncryptionKey []byte
+)
+
+var ErrNotConfigured = errors.New("token signing is not configured")
+
+// Init loads the keystore and sets up token issuing.
+func Init(cfg Config) error {
+	if cfg.Algorithm == "" {
+		cfg.Algorithm = ES256
+	}
+	if cfg.AccessTTL <= 0 || cfg.RefreshTTL <= 0 {
+		return fmt.Errorf("token lifetimes must be positive")
+	}
+	if n := len(cfg.KeyEncryptionKey); n != 0 && n != 32 {
+		return fmt.Errorf("key encryption key must be 32 bytes, got %d", n)
+	}
+	ks, err := LoadKeystore(cfg.KeystoreDir, cfg.ActiveKeyID, cfg.Algorithm)
+	if err != nil {
+		return err
+	}
+	keys, issuer, accessTTL, refreshTTL = ks, cfg.Issuer, cfg.AccessTTL, cfg.RefreshTTL
+	keyEncryptionKey = cfg.KeyEncryptionKey
+	return nil
+}
+
+// IssueAccessToken signs an access token for the subject within a client and
+// returns it with its lifetime. sessionID ties the token to the refresh token
+// family it was issued with. Clients with a signing key of their own get
+// tokens signed with it under a client specific issuer.
+func IssueAccessToken(ctx context.Context, clientID, subject, sessionID string) (string, time.Duration, error) {
+	if keys == nil {
+		return "", 0, ErrNotConfigured
+	}
+	key, tokenIssuer := keys.SigningKey(), issuer
+	own, err := tenantKey(ctx, clientID)
+	if err != nil {
+		return "", 0, err
+	}
+	if own != nil {
+		key, tokenIssuer = own, tenantIssuer(clientID)
+	}
+
+	now := time.Now()
+	claims := Claims{
+		ClientID:  clientID,
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    tokenIssuer,
+			Subject:   subject,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(accessTTL)),
+			ID:        newTokenID(),
+		},
+	}
+
+	t := jwt.NewWithClaims(key.method(), claims)
+	t.Header["kid"] = key.ID
+	signed, err := t.SignedString(key.Private)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to sign access token: %w", err)
+	}
+	return signed, accessTTL, nil
+}
+
+// PublishedKeys returns the keys verifiers need. Retired keys stay published
+// for the lifetime of the access tokens they signed.
+func PublishedKeys() []PublishedKey {
+	if keys == nil {
+		return nil
+	}
+	return keys.Published(accessTTL)
+}
+
+// ClientKey returns the public key of a client's own signing key, or nil when
+// the client uses the service keys.
+func ClientKey(ctx context.Context, clientID string) (*PublishedKey, error) {
+	key, err := tenantKey(ctx, clientID)
+	if err != nil || key == nil {
+		return nil, err
+	}
+	return &PublishedKey{Key: key, Status: KeyActive}, nil
+}
+
+// RotateSigningKey introduces a new signing key that starts signing tokens
+// after grace. An empty algorithm keeps the algorithm of the current key.
+func RotateSigningKey(algorithm string, grace time.Duration) (*Key, error) {
+	if keys == nil {
+		return nil, ErrNotConfigured
+	}
+	if algorithm == "" {
+		algorithm = keys.SigningKey().Algorithm
+	}
+	return keys.Rotate(algorithm, grace)
+}
+
+// MaintainKeys picks up keys rotated by other instances, rotates the signing
+// key once the newest key is older than interval, and deletes keys whose
+// tokens have all expired. A zero interval disables rotation.
+func MaintainKeys(interval, grace time.Duration) error {
+	if keys == nil {
+		return ErrNotConfigured
+	}
+	if err := keys.Reload(); err != nil {
+		return err
+	}
+	if interval > 0 {
+		newest, ok := keys.Newest()
+		if !ok {
+			return fmt.Errorf("keystore has no keys to rotate")
+		}
+		if time.Since(newest.CreatedAt) >= interval {
+			if _, err := keys.Rotate(newest.Algorithm, grace); err != nil {
+				return err
+			}
+		}
+	}
+	return keys.Prune(accessTTL)
+}
+
+func newTokenID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,155 @@
+package token
+
+import (
+	"auth-service/db"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Token types reported by Validate.
+const (
+	AccessTokenType  = "access_token"
+	RefreshTokenType = "refresh_token"
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+
+// Info describes a valid token.
+type Info struct {
+	TokenType string
+	ClientID  string
+	Subject   string
+	Issuer    string
+	ID        string // jti, empty for refresh tokens
+	SessionID string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// Validate checks a token issued by this service for clientID. Access tokens
+// are JWTs whose signature, issuer, expiry and tenant are verified; any other
+// value is looked up as an opaque refresh token. Both fail once their session
+// has been revoked.
+func Validate(ctx context.Context, clientID, t string) (*Info, error) {
+	if strings.Count(t, ".") == 2 {
+		return validateAccessToken(ctx, clientID, t)
+	}
+	return validateRefreshToken(ctx, clientID, t)
+}
+
+func validateAccessToken(ctx context.Context, clientID, t string) (*Info, error) {
+	if keys == nil {
+		return nil, ErrNotConfigured
+	}
+	// A client with its own key only accepts tokens signed with that key
+	own, err := tenantKey(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+	tokenIssuer := issuer
+	if own != nil {
+		tokenIssuer = tenantIssuer(clientID)
+	}
+
+	claims := &Claims{}
+	_, err = jwt.ParseWithClaims(t, claims, func(parsed *jwt.Token) (interface{}, error) {
+		kid, _ := parsed.Header["kid"].(string)
+		key, ok := keys.Key(kid)
+		if own != nil {
+			key, ok = own, kid == own.ID
+		}
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		if parsed.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("unexpected signing algorithm %q", parsed.Method.Alg())
+		}
+		return key.Public(), nil
+	}, jwt.WithValidMethods([]string{RS256, ES256, EdDSA}), jwt.WithIssuer(tokenIssuer), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if claims.ClientID != clientID {
+		return nil, fmt.Errorf("%w: token was issued for another client", ErrInvalidToken)
+	}
+
+	revoked, err := accessTokenRevoked(ctx, clientID, claims)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, fmt.Errorf("%w: token has been revoked", ErrInvalidToken)
+	}
+
+	info := &Info{
+		TokenType: AccessTokenType,
+		ClientID:  claims.ClientID,
+		Subject:   claims.Subject,
+		Issuer:    claims.Issuer,
+		ID:        claims.ID,
+		SessionID: claims.SessionID,
+		ExpiresAt: claims.ExpiresAt.Time,
+	}
+	if claims.IssuedAt != nil {
+		info.IssuedAt = claims.IssuedAt.Time
+	}
+	return info, nil
+}
+
+func validateRefreshToken(ctx context.Context, clientID, t string) (*Info, error) {
+	if err := db.EnsureTokenTables(clientID); err != nil {
+		return nil, err
+	}
+	info := &Info{TokenType: RefreshTokenType, ClientID: clientID, Issuer: issuer}
+	if own, err := tenantKey(ctx, clientID); err != nil {
+		return nil, err
+	} else if own != nil {
+		info.Issuer = tenantIssuer(clientID)
+	}
+	var issuedAt, expiresAt int64
+	var usable bool
+	query := fmt.Sprintf(`SELECT subject, family_id,
+		TIMESTAMPDIFF(SECOND, '1970-01-01', issued_at), TIMESTAMPDIFF(SECOND, '1970-01-01', expires_at),
+		expires_at > UTC_TIMESTAMP() AND rotated_at IS NULL AND revoked_at IS NULL
+		FROM client_%s.refresh_tokens WHERE token_hash = ?`, clientID)
+	err := db.MySQLClient.QueryRowContext(ctx, query, hashToken(t)).Scan(&info.Subject, &info.SessionID, &issuedAt, &expiresAt, &usable)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: unknown token", ErrInvalidToken)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up refresh token: %w", err)
+	}
+	if !usable {
+		return nil, fmt.Errorf("%w: refresh token is expired, rotated or revoked", ErrInvalidToken)
+	}
+	info.IssuedAt, info.ExpiresAt = time.Unix(issuedAt, 0), time.Unix(expiresAt, 0)
+	return info, nil
+}
+
+// accessTokenRevoked consults the client's revocation list: the token itself,
+// its login session and all sessions of its subject can have been revoked.
+func accessTokenRevoked(ctx context.Context, clientID string, claims *Claims) (bool, error) {
+	if err := db.EnsureTokenTables(clientID); err != nil {
+		return false, err
+	}
+	var issuedAt time.Time
+	if claims.IssuedAt != nil {
+		issuedAt = claims.IssuedAt.Time.UTC()
+	}
+	var revoked bool
+	query := fmt.Sprintf(`SELECT
+		EXISTS (SELECT 1 FROM client_%[1]s.revoked_tokens WHERE jti = ?) OR
+		EXISTS (SELECT 1 FROM client_%[1]s.refresh_tokens WHERE family_id = ? AND revoked_at IS NOT NULL) OR
+		EXISTS (SELECT 1 FROM client_%[1]s.session_revocations WHERE subject = ? AND revoked_at > ?)`, clientID)
+	err := db.MySQLClient.QueryRowContext(ctx, query, claims.ID, claims.SessionID, claims.Subject, issuedAt).Scan(&revoked)
+	if err != nil {
+		return false, fmt.Errorf("failed to check token revocation: %w", err)
+	}
+	return revoked, nil
+}